@@ -23,6 +23,7 @@ import io.flutter.plugin.common.MethodChannel.MethodCallHandler
 import io.flutter.plugin.common.MethodChannel.Result
 import io.flutter.plugin.common.PluginRegistry
 import mobile.Mobile
+import org.json.JSONObject
 
 /**
  * CloudflaredTunnelPlugin - Flutter plugin that manages cloudflared tunnel via foreground service.
@@ -264,8 +265,8 @@ class CloudflaredTunnelPlugin : FlutterPlugin, MethodCallHandler, EventChannel.S
         }
 
         try {
-            val tunnelId = Mobile.validateToken(token)
-            result.success(tunnelId)
+            val info = JSONObject(Mobile.validateToken(token))
+            result.success(info.getString("tunnelId"))
         } catch (e: Exception) {
             result.error("INVALID_TOKEN", e.message, null)
         }
@@ -0,0 +1,155 @@
+package mobile
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TunnelHandle tracks a tunnel started with StartAsync
+type TunnelHandle struct {
+	tunnel    *Tunnel
+	connected chan struct{}
+	done      chan struct{}
+	err       error
+}
+
+// StartAsync starts the tunnel in the background and returns immediately.
+// Use the handle to wait for the connection or for the tunnel to stop.
+func (t *Tunnel) StartAsync() *TunnelHandle {
+	h := &TunnelHandle{
+		tunnel:    t,
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	goroutineDone := t.trackGoroutine()
+	go func() {
+		defer goroutineDone()
+		defer close(h.done)
+		h.err = t.start(h.connected)
+	}()
+	return h
+}
+
+// waitTimer returns a channel firing after timeoutMs, or never if timeoutMs <= 0
+func waitTimer(timeoutMs int) (<-chan time.Time, func()) {
+	if timeoutMs <= 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	return timer.C, func() { timer.Stop() }
+}
+
+// WaitConnected blocks until the tunnel is connected, it stops, or timeoutMs
+// elapses (timeoutMs <= 0 waits indefinitely). It returns nil once connected,
+// the terminal error if the tunnel stopped first, or an ErrCodeTimeout error.
+func (h *TunnelHandle) WaitConnected(timeoutMs int) error {
+	// A tunnel that connected and then stopped still counts as connected
+	select {
+	case <-h.connected:
+		return nil
+	default:
+	}
+
+	timeout, stop := waitTimer(timeoutMs)
+	defer stop()
+
+	select {
+	case <-h.connected:
+		return nil
+	case <-h.done:
+		select {
+		case <-h.connected:
+			return nil
+		default:
+		}
+		if h.err != nil {
+			return h.err
+		}
+		return errors.New("tunnel stopped before connecting")
+	case <-timeout:
+		return newTunnelError(ErrCodeTimeout, fmt.Errorf("tunnel not connected after %dms", timeoutMs))
+	}
+}
+
+// WaitStopped blocks until the tunnel stops or timeoutMs elapses (timeoutMs <= 0
+// waits indefinitely). It returns the terminal error, nil after a clean stop,
+// or an ErrCodeTimeout error if the tunnel is still running.
+func (h *TunnelHandle) WaitStopped(timeoutMs int) error {
+	timeout, stop := waitTimer(timeoutMs)
+	defer stop()
+
+	select {
+	case <-h.done:
+		return h.err
+	case <-timeout:
+		return newTunnelError(ErrCodeTimeout, fmt.Errorf("tunnel still running after %dms", timeoutMs))
+	}
+}
+
+// IsStopped returns true once the tunnel has stopped
+func (h *TunnelHandle) IsStopped() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops the tunnel. Use WaitStopped to wait for it to finish.
+func (h *TunnelHandle) Stop() {
+	h.tunnel.Stop()
+}
+
+// StopWithTimeout stops the tunnel and waits for it to shut down (see
+// Tunnel.StopWithTimeout). It returns true if the shutdown was graceful.
+func (h *TunnelHandle) StopWithTimeout(timeoutMs int) (bool, error) {
+	return h.tunnel.StopWithTimeout(timeoutMs)
+}
+
+// GetTunnel returns the tunnel behind the handle
+func (h *TunnelHandle) GetTunnel() *Tunnel {
+	return h.tunnel
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// StartTunnelAsync starts the global tunnel in the background and returns a
+// handle to wait on, instead of blocking like StartTunnelWithCallback
+func StartTunnelAsync(token string, originURL string, callback TunnelCallback) (*TunnelHandle, error) {
+	tunnel, err := replaceGlobalTunnel(func() (*Tunnel, error) {
+		return NewTunnel(token, originURL, callback)
+	}, callback)
+	if err != nil {
+		return nil, err
+	}
+	return tunnel.StartAsync(), nil
+}
+
+// StartTunnelWithConfigAsync starts the global tunnel from a JSON config (see
+// CreateTunnel) in the background and returns a handle to wait on
+func StartTunnelWithConfigAsync(configJSON string, callback TunnelCallback) (*TunnelHandle, error) {
+	config, err := parseTunnelConfig(configJSON)
+	if err != nil {
+		return nil, err
+	}
+	tunnel, err := replaceGlobalTunnel(func() (*Tunnel, error) {
+		return newTunnel(config, callback), nil
+	}, callback)
+	if err != nil {
+		return nil, err
+	}
+	return tunnel.StartAsync(), nil
+}
+
+// StartTunnelByIDAsync starts a tunnel created with CreateTunnel in the background
+func StartTunnelByIDAsync(id string) (*TunnelHandle, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return nil, err
+	}
+	return tunnel.StartAsync(), nil
+}
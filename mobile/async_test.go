@@ -0,0 +1,94 @@
+package mobile
+
+import "testing"
+
+func TestTunnelHandleWaits(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.holdRegistrations()
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+
+	handle := tunnel.StartAsync()
+	t.Cleanup(func() { _, _ = tunnel.StopWithTimeout(0) })
+	if err := handle.WaitConnected(50); classifyError(err) != ErrCodeTimeout {
+		t.Errorf("WaitConnected while registrations are held = %v, want a timeout", err)
+	}
+	if err := handle.WaitStopped(50); classifyError(err) != ErrCodeTimeout {
+		t.Errorf("WaitStopped while running = %v, want a timeout", err)
+	}
+	if handle.IsStopped() {
+		t.Fatal("handle stopped while the tunnel is connecting")
+	}
+
+	handle.Stop()
+	if err := handle.WaitStopped(5000); err != nil {
+		t.Errorf("WaitStopped after Stop = %v, want a clean stop", err)
+	}
+	if !handle.IsStopped() {
+		t.Error("handle not stopped after WaitStopped returned")
+	}
+	if err := handle.WaitConnected(0); err == nil {
+		t.Error("WaitConnected succeeded for a tunnel that never connected")
+	}
+}
+
+func TestTunnelHandleAfterConnect(t *testing.T) {
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+	handle := startConnected(t, tunnel)
+	if handle.GetTunnel() != tunnel {
+		t.Error("handle returned another tunnel")
+	}
+
+	graceful, err := handle.StopWithTimeout(5000)
+	if err != nil || !graceful {
+		t.Fatalf("StopWithTimeout = %v, %v; want graceful", graceful, err)
+	}
+	if !handle.IsStopped() {
+		t.Error("handle not stopped after StopWithTimeout returned")
+	}
+	// A tunnel that connected and then stopped still counts as connected
+	if err := handle.WaitConnected(0); err != nil {
+		t.Errorf("WaitConnected after a stop = %v, want nil", err)
+	}
+	if err := handle.WaitStopped(0); err != nil {
+		t.Errorf("WaitStopped = %v, want a clean stop", err)
+	}
+}
+
+func TestTunnelHandleReturnsTerminalError(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.reject("Unauthorized: Invalid tunnel secret")
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+
+	handle := tunnel.StartAsync()
+	err := handle.WaitConnected(5000)
+	if code := classifyError(err); code != ErrCodeAuthRejected {
+		t.Errorf("WaitConnected = %v (%s), want the registration error", err, code)
+	}
+	if stopped := handle.WaitStopped(0); stopped != err {
+		t.Errorf("WaitStopped = %v, want the same terminal error %v", stopped, err)
+	}
+}
+
+func TestStartTunnelByIDAsync(t *testing.T) {
+	edge := newFakeEdge(t)
+	id := createFakeEdgeTunnel(t, edge)
+
+	handle, err := StartTunnelByIDAsync(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handle.WaitConnected(5000); err != nil {
+		t.Fatalf("tunnel did not connect: %v", err)
+	}
+	if err := StopTunnelByID(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := handle.WaitStopped(5000); err != nil {
+		t.Errorf("WaitStopped after StopTunnelByID = %v, want a clean stop", err)
+	}
+
+	if _, err := StartTunnelByIDAsync("no-such-tunnel"); err == nil {
+		t.Error("StartTunnelByIDAsync succeeded for an unknown ID")
+	}
+}
@@ -0,0 +1,191 @@
+package mobile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+// resolveBindAddress turns a bind address or interface name (e.g. "wlan0") into
+// the source IP for edge connections. An empty spec lets the OS choose.
+// For an interface, an IPv4 address is preferred unless the IP version is IPv6Only.
+func resolveBindAddress(spec string, version allregions.ConfigIPVersion) (net.IP, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(spec); ip != nil {
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(spec)
+	if err != nil {
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid bind address or interface %q: %w", spec, err))
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("interface %s is down", spec))
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %w", spec, err)
+	}
+
+	var v4, v6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			if v4 == nil {
+				v4 = ipNet.IP
+			}
+		} else if v6 == nil {
+			v6 = ipNet.IP
+		}
+	}
+
+	switch {
+	case version == allregions.IPv6Only && v6 != nil:
+		return v6, nil
+	case version != allregions.IPv6Only && v4 != nil:
+		return v4, nil
+	case version == allregions.Auto && v6 != nil:
+		return v6, nil
+	default:
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("interface %s has no usable address", spec))
+	}
+}
+
+// bindIPVersion restricts the edge IP version to the bind address family, since a
+// connection bound to an IPv4 address cannot reach an IPv6 edge and vice versa
+func bindIPVersion(bindIP net.IP, version allregions.ConfigIPVersion) allregions.ConfigIPVersion {
+	if bindIP == nil || version != allregions.Auto {
+		return version
+	}
+	if bindIP.To4() != nil {
+		return allregions.IPv4Only
+	}
+	return allregions.IPv6Only
+}
+
+// daemonRun is the running tunnel daemon and what is needed to start it again
+// with another bind address. cloudflared copies the bind address and edge
+// addresses when the daemon starts, so they can only change with a restart.
+type daemonRun struct {
+	config     *supervisor.TunnelConfig
+	edgeAddrs  []string                   // before filtering by the bind address family
+	configured bool                       // edgeAddrs come from TunnelConfig.EdgeAddrs
+	ipVersion  allregions.ConfigIPVersion // as configured, before bindIPVersion
+	cancel     context.CancelFunc
+	next       *supervisor.TunnelConfig // queued by Rebind
+}
+
+// withBindAddress returns a copy of the daemon config bound to bindIP, with the
+// IP version and edge addresses narrowed to its family
+func (r *daemonRun) withBindAddress(bindIP net.IP) (*supervisor.TunnelConfig, error) {
+	next := *r.config
+	next.EdgeBindAddr = bindIP
+	next.EdgeIPVersion = bindIPVersion(bindIP, r.ipVersion)
+	if r.edgeAddrs != nil {
+		next.EdgeAddrs = filterEdgeAddrs(r.edgeAddrs, next.EdgeIPVersion)
+		if len(next.EdgeAddrs) == 0 && r.configured {
+			return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("no edge address is reachable from %s", bindAddressString(bindIP)))
+		}
+		if len(next.EdgeAddrs) == 0 {
+			next.EdgeAddrs = nil
+		}
+	}
+	return &next, nil
+}
+
+// Rebind moves the edge connections to a new bind address or interface name.
+// The tunnel daemon is restarted with the new address while the orchestrator and
+// origin proxy keep running. An empty address lets the OS choose again.
+func (t *Tunnel) Rebind(addr string) error {
+	t.mu.RLock()
+	version, err := parseEdgeIPVersion(t.config.EdgeIPVersion)
+	t.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	bindIP, err := resolveBindAddress(addr, version)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	run := t.daemonRun
+	if run == nil {
+		t.config.BindAddress = addr
+		t.bindIP = bindIP
+		t.mu.Unlock()
+		t.logCallback(0, "[bind] Bind address set to %q, applies on next start", addr)
+		return nil
+	}
+	next, err := run.withBindAddress(bindIP)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	t.config.BindAddress = addr
+	t.bindIP = bindIP
+	run.next = next
+	cancel := run.cancel
+	t.mu.Unlock()
+
+	reason := fmt.Sprintf("Rebound to %s", bindAddressString(bindIP))
+	if connected := t.readyConnections(); connected > 0 {
+		t.trackReconnect(reason, int(connected))
+	} else {
+		t.logCallback(0, "[bind] %s, restarting tunnel daemon", reason)
+	}
+	cancel()
+	return nil
+}
+
+// bindAddressString formats a bind IP for logs and status ("auto" when unset)
+func bindAddressString(ip net.IP) string {
+	if ip == nil {
+		return "auto"
+	}
+	return ip.String()
+}
+
+// GetBindAddress returns the source address of the edge connections, or "auto"
+func (t *Tunnel) GetBindAddress() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return bindAddressString(t.bindIP)
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// RebindTunnel moves the global tunnel's edge connections to a new bind address
+// or interface name (e.g. "wlan0", "rmnet0") without restarting the tunnel
+func RebindTunnel(addr string) error {
+	tunnelMu.Lock()
+	tunnel := globalTunnel
+	tunnelMu.Unlock()
+
+	if tunnel == nil {
+		return errors.New("no tunnel is running")
+	}
+	return tunnel.Rebind(addr)
+}
+
+// RebindTunnelByID moves the edge connections of a tunnel created with CreateTunnel
+func RebindTunnelByID(id string, addr string) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	return tunnel.Rebind(addr)
+}
@@ -0,0 +1,167 @@
+package mobile
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+// registrationsFrom returns the registrations the edge accepted from source
+func registrationsFrom(edge *fakeEdge, source string) int {
+	count := 0
+	for _, registration := range edge.getRegistrations() {
+		if registration.Source == source {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRebindRestartsDaemonWithNewAddress(t *testing.T) {
+	if listener, err := net.Listen("tcp", "127.0.0.2:0"); err != nil {
+		t.Skipf("127.0.0.2 is not a local address: %v", err)
+	} else {
+		listener.Close()
+	}
+
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{BindAddress: "127.0.0.1"}, nil)
+	handle := tunnel.StartAsync()
+	defer tunnel.Stop()
+	if err := handle.WaitConnected(5000); err != nil {
+		t.Fatalf("tunnel did not connect: %v", err)
+	}
+	if !waitFor(5*time.Second, func() bool { return registrationsFrom(edge, "127.0.0.1") == 2 }) {
+		t.Fatalf("registrations = %+v, want 2 from 127.0.0.1", edge.getRegistrations())
+	}
+	tunnel.mu.RLock()
+	registry := tunnel.registry
+	tunnel.mu.RUnlock()
+
+	if err := tunnel.Rebind("127.0.0.2"); err != nil {
+		t.Fatalf("Rebind failed: %v", err)
+	}
+	if !waitFor(5*time.Second, func() bool { return registrationsFrom(edge, "127.0.0.2") == 2 }) {
+		t.Fatalf("registrations = %+v, want 2 from 127.0.0.2", edge.getRegistrations())
+	}
+	if !waitFor(5*time.Second, func() bool { return edge.openConns() == 2 && tunnel.IsConnected() }) {
+		t.Fatalf("after rebind: %d open edge connections, state %s", edge.openConns(), tunnel.GetStateString())
+	}
+
+	if got := tunnel.GetBindAddress(); got != "127.0.0.2" {
+		t.Errorf("GetBindAddress() = %q, want 127.0.0.2", got)
+	}
+	orchestrators := edge.getOrchestrators()
+	if len(orchestrators) != 2 || orchestrators[0] != orchestrators[1] {
+		t.Errorf("daemon started %d times with orchestrators %p, want twice with the same one", len(orchestrators), orchestrators)
+	}
+	tunnel.mu.RLock()
+	sameRegistry := tunnel.registry == registry
+	tunnel.mu.RUnlock()
+	if !sameRegistry {
+		t.Error("rebind replaced the metrics registry")
+	}
+	if handle.IsStopped() {
+		t.Fatalf("tunnel stopped after rebind: %v", handle.WaitStopped(0))
+	}
+}
+
+func TestRebindRejectsUnreachableEdge(t *testing.T) {
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+	handle := tunnel.StartAsync()
+	defer tunnel.Stop()
+	if err := handle.WaitConnected(5000); err != nil {
+		t.Fatalf("tunnel did not connect: %v", err)
+	}
+
+	// The only edge address is IPv4, so an IPv6 bind address cannot reach it
+	err := tunnel.Rebind("::1")
+	if code := classifyError(err); code != ErrCodeInvalidConfig {
+		t.Fatalf("Rebind(::1) = %v (code %d), want an invalid config error", err, code)
+	}
+	if got := tunnel.GetBindAddress(); got != "auto" {
+		t.Errorf("GetBindAddress() = %q after a rejected rebind, want auto", got)
+	}
+	if orchestrators := edge.getOrchestrators(); len(orchestrators) != 1 {
+		t.Errorf("daemon started %d times, want once", len(orchestrators))
+	}
+}
+
+// usableInterface returns an interface that is up and has a non-loopback IPv4 address
+func usableInterface(t *testing.T) (string, net.IP) {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("cannot list interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLinkLocalUnicast() {
+				return iface.Name, ipNet.IP
+			}
+		}
+	}
+	t.Skip("no interface with a usable IPv4 address")
+	return "", nil
+}
+
+// loopbackInterface returns the name of the loopback interface
+func loopbackInterface(t *testing.T) string {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("cannot list interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestResolveBindAddress(t *testing.T) {
+	tests := []struct {
+		spec    string
+		version allregions.ConfigIPVersion
+		want    net.IP
+	}{
+		{"", allregions.Auto, nil},
+		{" 192.0.2.10 ", allregions.Auto, net.ParseIP("192.0.2.10")},
+		{"2001:db8::1", allregions.IPv4Only, net.ParseIP("2001:db8::1")},
+	}
+	for _, tt := range tests {
+		got, err := resolveBindAddress(tt.spec, tt.version)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("resolveBindAddress(%q) = %v, %v; want %v", tt.spec, got, err, tt.want)
+		}
+	}
+
+	// Anything that isn't an IP is looked up as an interface name
+	for _, spec := range []string{"no-such-iface0", "300.1.1.1", loopbackInterface(t)} {
+		_, err := resolveBindAddress(spec, allregions.Auto)
+		var tunnelErr *TunnelError
+		if !errors.As(err, &tunnelErr) || tunnelErr.Code != ErrCodeInvalidConfig {
+			t.Errorf("resolveBindAddress(%q) = %v, want an invalid config error", spec, err)
+		}
+	}
+
+	name, want := usableInterface(t)
+	for _, version := range []allregions.ConfigIPVersion{allregions.Auto, allregions.IPv4Only} {
+		if got, err := resolveBindAddress(name, version); err != nil || got.To4() == nil {
+			t.Errorf("resolveBindAddress(%q, %v) = %v, %v; want an IPv4 address like %s", name, version, got, err, want)
+		}
+	}
+}
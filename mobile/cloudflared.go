@@ -10,21 +10,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"net/netip"
 	"runtime/debug"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/rs/zerolog"
 
 	"github.com/cloudflare/cloudflared/client"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
-	"github.com/cloudflare/cloudflared/edgediscovery"
-	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
 	"github.com/cloudflare/cloudflared/features"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/ingress/origins"
@@ -34,53 +34,6 @@ import (
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
 
-// metricsResetMu protects the metrics registry reset
-var metricsResetMu sync.Mutex
-var tunnelStartCount int
-
-// resetPrometheusRegistry creates a fresh Prometheus registry and replaces the default one.
-// This is necessary because cloudflared uses MustRegister() which panics on duplicate registration,
-// and the Go runtime persists in mobile apps even after stopping the tunnel.
-func resetPrometheusRegistry() {
-	metricsResetMu.Lock()
-	defer metricsResetMu.Unlock()
-
-	tunnelStartCount++
-
-	// Create a completely new registry
-	newRegistry := prometheus.NewRegistry()
-
-	// Register the default Go collectors that are normally registered
-	newRegistry.MustRegister(collectors.NewGoCollector())
-	newRegistry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
-
-	// Replace the default registerer and gatherer
-	// This is a bit of a hack, but it's the only way to reset the registry
-	// without modifying cloudflared source code
-	prometheus.DefaultRegisterer = newRegistry
-	prometheus.DefaultGatherer = newRegistry
-}
-
-// cleanupMetricsState is a wrapper for compatibility
-func cleanupMetricsState() {
-	resetPrometheusRegistry()
-}
-
-// init configures the DNS resolver to use Cloudflare's 1.1.1.1
-// This is necessary on mobile where the default resolver may not work
-func init() {
-	net.DefaultResolver = &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: time.Second * 10,
-			}
-			// Use Cloudflare's 1.1.1.1 DNS
-			return d.DialContext(ctx, "udp", "1.1.1.1:53")
-		},
-	}
-}
-
 // CloudFlare Origin SSL ECC Certificate Authority
 // This is the CA that signs certificates for Cloudflare edge servers (quic.cftunnel.com, h2.cftunnel.com)
 var cloudflareOriginECCCA = []byte(`-----BEGIN CERTIFICATE-----
@@ -218,65 +171,187 @@ type TunnelCallback interface {
 // TunnelConfig holds the configuration for a tunnel
 type TunnelConfig struct {
 	// Token is the base64-encoded tunnel token from Cloudflare dashboard
-	Token string
-	// OriginURL is the local URL to proxy traffic to (e.g., "http://localhost:8080")
-	OriginURL string
+	Token string `json:"token"`
+	// OriginURL is the local URL to proxy traffic to (e.g., "http://localhost:8080").
+	// When set, it becomes the catch-all rule of a locally-managed ingress.
+	OriginURL string `json:"originUrl"`
+	// IngressRules are optional hostname/path rules evaluated before the OriginURL catch-all
+	IngressRules []IngressRule `json:"ingress"`
 	// HAConnections is the number of high availability connections (default: 4)
-	HAConnections int
+	HAConnections int `json:"haConnections"`
 	// EnablePostQuantum enables post-quantum cryptography
-	EnablePostQuantum bool
+	EnablePostQuantum bool `json:"enablePostQuantum"`
+	// QuickTunnel requests an ephemeral trycloudflare.com tunnel instead of using Token
+	QuickTunnel bool `json:"quickTunnel"`
+	// QuickTunnelService is the base URL of the quick tunnel service (default: https://api.trycloudflare.com)
+	QuickTunnelService string `json:"quickTunnelService"`
+	// LogLevel is the minimum level forwarded to OnLog/OnLogEvent
+	// ("debug", "info", "warn", "error"; default: "debug")
+	LogLevel string `json:"logLevel"`
+	// MetricsAddress enables a loopback listener serving /metrics, /ready,
+	// /healthcheck and /quicktunnel (e.g. "127.0.0.1:20241"). Empty disables it.
+	MetricsAddress string `json:"metricsAddress"`
+	// Protocol selects the edge transport: "auto" (default) starts with QUIC and
+	// falls back to HTTP/2 after repeated QUIC failures, "quic" or "http2" pin it
+	Protocol string `json:"protocol"`
+	// Resolver controls DNS lookups made by the tunnel (default: 1.1.1.1 and
+	// 1.0.0.1 over UDP, then the system resolver)
+	Resolver *ResolverConfig `json:"resolver,omitempty"`
+	// CacheDir is an app-supplied directory where the last known-good edge
+	// addresses and protocol, and the connector ID, are persisted per tunnel ID.
+	// Empty disables the cache; quick tunnels are never cached.
+	CacheDir string `json:"cacheDir"`
+	// EdgeCacheMaxAgeSeconds is how long cached edge addresses are used (default: 24h)
+	EdgeCacheMaxAgeSeconds int `json:"edgeCacheMaxAgeSeconds"`
+	// EdgeAddrs pins the edge addresses ("host:port"), skipping edge discovery and the cache
+	EdgeAddrs []string `json:"edgeAddrs,omitempty"`
+	// EdgeIPVersion is the IP version used to reach the edge: "auto" (default), "4" or "6"
+	EdgeIPVersion string `json:"edgeIpVersion"`
+	// Region selects the edge region (e.g. "us"); empty uses the region from the token
+	Region string `json:"region"`
+	// BindAddress is the local IP or interface name (e.g. "wlan0") edge connections
+	// are made from. Empty lets the OS choose.
+	BindAddress string `json:"bindAddress"`
+	// Retries is the number of times a failing HA connection is retried with
+	// backoff (default: 5). 0 disables retries; -1 or omitted uses the default.
+	Retries int `json:"retries"`
+	// MaxEdgeAddrRetries is the number of edge addresses tried per connection
+	// before giving up (default: 8). -1 or omitted uses the default.
+	MaxEdgeAddrRetries int `json:"maxEdgeAddrRetries"`
+	// GracePeriodMs is how long in-flight requests may finish on shutdown (default: 30000)
+	GracePeriodMs int `json:"gracePeriodMs"`
+	// RPCTimeoutMs bounds the registration RPCs with the edge (default: 5000)
+	RPCTimeoutMs int `json:"rpcTimeoutMs"`
+	// ConnectTimeoutMs makes Start fail with ErrCodeConnectTimeout if no edge
+	// connection is registered in time (default: 0, wait indefinitely)
+	ConnectTimeoutMs int `json:"connectTimeoutMs"`
+	// Tags are app-supplied connector tags shown in the dashboard (e.g. device model,
+	// app version). "platform" defaults to "mobile"; "ID" is reserved.
+	Tags map[string]string `json:"tags,omitempty"`
+	// ConnectorID pins the connector ID (a UUID). When empty, the ID is persisted
+	// per tunnel in CacheDir so restarts reuse it. The ID is only stable across
+	// restarts if one of the two is set: without them every start generates a
+	// new ID and shows up as a new connector in the dashboard.
+	ConnectorID string `json:"connectorId"`
+}
+
+// connectorArch is reported to the edge as the connector architecture
+const connectorArch = "mobile"
+
+// Supervisor defaults used when the TunnelConfig fields are unset
+const (
+	// unsetCount marks Retries and MaxEdgeAddrRetries as unset, since 0 is a valid value
+	unsetCount = -1
+
+	defaultRetries            = 5
+	defaultMaxEdgeAddrRetries = 8
+	defaultGracePeriod        = 30 * time.Second
+	defaultRPCTimeout         = 5 * time.Second
+)
+
+// durationMs converts a millisecond setting, falling back to def when unset
+func durationMs(ms int, def time.Duration) time.Duration {
+	if ms <= 0 {
+		return def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// intOrDefault returns v, or def when v is unset (negative)
+func intOrDefault(v int, def int) int {
+	if v < 0 {
+		return def
+	}
+	return v
 }
 
 // Tunnel represents a running cloudflared tunnel instance
 type Tunnel struct {
-	mu             sync.RWMutex
-	ctx            context.Context
-	cancel         context.CancelFunc
-	config         *TunnelConfig
-	callback       TunnelCallback
-	state          TunnelState
-	lastError      error
-	connectedAt    time.Time
-	log            *zerolog.Logger
-	graceShutdownC chan struct{}
+	mu                  sync.RWMutex
+	id                  string
+	ctx                 context.Context
+	cancel              context.CancelFunc
+	config              *TunnelConfig
+	callback            TunnelCallback
+	state               TunnelState
+	lastError           error
+	connectedAt         time.Time
+	lastReconnectAt     time.Time
+	lastOriginError     time.Time
+	stateHistory        []StateTransition
+	configSource        string
+	registry            *prometheus.Registry
+	metrics             *tunnelMetrics
+	connectorID         string
+	tunnelID            string
+	accountTag          string
+	reconnectCount      int
+	tags                []pogs.Tag
+	connections         map[uint8]*ConnectionInfo
+	connectionCallback  ConnectionEventCallback
+	logEventCallback    LogEventCallback
+	minLogLevel         atomic.Int32
+	metricsAddr         string
+	quickTunnelHostname string
+	activeProtocol      string
+	edgeCache           *edgeCache
+	usedEdgeCache       bool
+	bindIP              net.IP
+	daemonRun           *daemonRun
+	reconnectCh         chan supervisor.ReconnectSignal
+	pendingReconnect    *pendingReconnect
+	connectedC          chan struct{}
+	connectTimedOut     bool
+	log                 *zerolog.Logger
+	graceShutdownC      chan struct{}
+	daemon              tunnelDaemon
+	resources           resourceCounter
+	runGoroutines       sync.WaitGroup
+	done                chan struct{}
+	stopping            bool
+	stopForced          bool
+	// edgeRootCAs replace the embedded Cloudflare CAs when set, e.g. to trust a test edge
+	edgeRootCAs *x509.CertPool
+	// previousRun is closed once the global tunnel this one replaced has stopped
+	previousRun <-chan struct{}
 }
 
+// tunnelDaemon runs a tunnel's edge connections until ctx is cancelled or
+// graceShutdownC is closed and they have drained
+type tunnelDaemon func(ctx context.Context, config *supervisor.TunnelConfig, orchestrator *orchestration.Orchestrator, connectedSignal *signal.Signal, reconnectCh chan supervisor.ReconnectSignal, graceShutdownC <-chan struct{}) error
+
 var (
 	// globalTunnel is the singleton tunnel instance
 	globalTunnel *Tunnel
 	tunnelMu     sync.Mutex
 )
 
-// callbackWriter is a custom io.Writer that sends log messages to the callback
+// callbackWriter is a zerolog.LevelWriter that turns log lines into structured
+// events for the tunnel's callbacks
 type callbackWriter struct {
-	callback TunnelCallback
+	tunnel *Tunnel
 }
 
 func (w *callbackWriter) Write(p []byte) (n int, err error) {
-	if w.callback != nil {
-		w.callback.OnLog(1, string(p))
-	}
-	return len(p), nil
+	return w.WriteLevel(zerolog.NoLevel, p)
 }
 
-// logToCallback sends a log message to the callback
-func logToCallback(callback TunnelCallback, level int, format string, args ...interface{}) {
-	if callback != nil {
-		msg := fmt.Sprintf(format, args...)
-		callback.OnLog(level, msg)
-	}
+func (w *callbackWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	w.tunnel.observeLogLine(p)
+	w.tunnel.emitLogEvent(level, parseLogEvent(level, p))
+	return len(p), nil
 }
 
 // parseToken decodes the base64 tunnel token
 func parseToken(tokenStr string) (*connection.TunnelToken, error) {
 	content, err := base64.StdEncoding.DecodeString(tokenStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode token: %w", err)
+		return nil, newTunnelError(ErrCodeInvalidToken, fmt.Errorf("failed to decode token: %w", err))
 	}
 
 	var token connection.TunnelToken
 	if err := json.Unmarshal(content, &token); err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
+		return nil, newTunnelError(ErrCodeInvalidToken, fmt.Errorf("failed to parse token: %w", err))
 	}
 
 	return &token, nil
@@ -286,107 +361,274 @@ func parseToken(tokenStr string) (*connection.TunnelToken, error) {
 // Call Start() to begin the tunnel connection.
 func NewTunnel(token string, originURL string, callback TunnelCallback) (*Tunnel, error) {
 	if token == "" {
-		return nil, errors.New("token is required")
+		return nil, newTunnelError(ErrCodeInvalidToken, errors.New("token is required"))
 	}
 
 	config := &TunnelConfig{
-		Token:         token,
-		OriginURL:     originURL,
-		HAConnections: 4,
+		Token:              token,
+		OriginURL:          originURL,
+		HAConnections:      4,
+		Retries:            unsetCount,
+		MaxEdgeAddrRetries: unsetCount,
 	}
 
-	// Create logger that sends to callback
-	writer := &callbackWriter{callback: callback}
-	logger := zerolog.New(writer).With().Timestamp().Logger()
+	tunnel := newTunnel(config, callback)
+	tunnel.logCallback(0, "[NewTunnel] Creating tunnel instance")
+	tunnel.logCallback(0, "[NewTunnel] Token length: %d", len(token))
+	tunnel.logCallback(0, "[NewTunnel] OriginURL: %s", originURL)
 
-	logToCallback(callback, 0, "[NewTunnel] Creating tunnel instance")
-	logToCallback(callback, 0, "[NewTunnel] Token length: %d", len(token))
-	logToCallback(callback, 0, "[NewTunnel] OriginURL: %s", originURL)
+	return tunnel, nil
+}
 
+// newTunnel creates a Tunnel with a logger that forwards to the callback
+func newTunnel(config *TunnelConfig, callback TunnelCallback) *Tunnel {
 	t := &Tunnel{
 		config:         config,
 		callback:       callback,
 		state:          StateDisconnected,
-		log:            &logger,
 		graceShutdownC: make(chan struct{}),
+		registry:       prometheus.NewRegistry(),
+		daemon:         supervisor.StartTunnelDaemon,
 	}
+	t.metrics = newTunnelMetrics(t)
 
-	return t, nil
+	level, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		level = zerolog.DebugLevel
+	}
+	t.minLogLevel.Store(int32(level))
+
+	// Create logger that sends to callback
+	logger := zerolog.New(&callbackWriter{tunnel: t}).With().Timestamp().Logger()
+	t.log = &logger
+
+	return t
 }
 
 // Start begins the tunnel connection.
 // This is a blocking call that will return when the tunnel is stopped.
-func (t *Tunnel) Start() (err error) {
+// Use StartAsync for non-blocking operation.
+func (t *Tunnel) Start() error {
+	return t.start(make(chan struct{}))
+}
+
+// start runs the tunnel, closing connected when it first reaches the connected state
+func (t *Tunnel) start(connected chan struct{}) (err error) {
 	t.logCallback(0, "[Start] Beginning tunnel start sequence")
 
+	// Signal StopWithTimeout once everything below has finished
+	var done chan struct{}
+	defer func() {
+		if done != nil {
+			close(done)
+		}
+	}()
+
 	// Recover from any panics
 	defer func() {
 		if r := recover(); r != nil {
 			stack := string(debug.Stack())
 			errMsg := fmt.Sprintf("tunnel panic: %v\nStack trace:\n%s", r, stack)
 			t.logCallback(2, "[Start] PANIC: %s", errMsg)
-			err = fmt.Errorf("tunnel panic: %v", r)
+			err = newTunnelError(ErrCodePanic, fmt.Errorf("tunnel panic: %v", r))
 			t.setError(err)
 		}
-		// Ensure we clean up state
+		if done == nil {
+			// Another run owns the tunnel state
+			return
+		}
+		// Release the run's context; Stop is a no-op from here on
 		t.mu.Lock()
-		t.state = StateDisconnected
+		t.cancel()
+		t.cancel = nil
 		t.mu.Unlock()
-		t.logCallback(0, "[Start] Tunnel stopped, state set to disconnected")
+		t.runGoroutines.Wait()
+		t.setRunning(false)
+		// Ensure we clean up state. A failed run stays in the error state.
+		t.mu.RLock()
+		failed := t.state == StateError
+		t.mu.RUnlock()
+		if !failed {
+			t.setState(StateDisconnected, t.stopMessage())
+		}
+		t.logCallback(0, "[Start] Tunnel stopped, state: %s", t.GetStateString())
 	}()
 
 	t.mu.Lock()
-	if t.state == StateConnecting || t.state == StateConnected {
+	// Only an idle tunnel may start; a run still holding cancel owns the state
+	idle := t.cancel == nil && (t.state == StateDisconnected || t.state == StateError)
+	if !idle {
+		state := t.state
+		t.mu.Unlock()
+		t.logCallback(1, "[Start] Tunnel already running, state: %v", state)
+		return newTunnelError(ErrCodeAlreadyRunning, errors.New("tunnel is already running"))
+	}
+	if changed, err := t.setStateLocked(StateConnecting, "Starting tunnel connection..."); err != nil || !changed {
+		state := t.state
 		t.mu.Unlock()
-		t.logCallback(1, "[Start] Tunnel already running, state: %v", t.state)
-		return errors.New("tunnel is already running")
+		t.logCallback(1, "[Start] Tunnel already running, state: %v", state)
+		return newTunnelError(ErrCodeAlreadyRunning, errors.New("tunnel is already running"))
 	}
 
 	t.ctx, t.cancel = context.WithCancel(context.Background())
-	t.state = StateConnecting
+	ctx := t.ctx
 	t.graceShutdownC = make(chan struct{})
+	t.done = make(chan struct{})
+	done = t.done
+	t.stopping = false
+	t.stopForced = false
+	t.connections = make(map[uint8]*ConnectionInfo)
+	t.connectedC = connected
+	t.connectTimedOut = false
+	t.reconnectCount = 0
+	t.activeProtocol = ""
+	t.usedEdgeCache = false
 	t.mu.Unlock()
+	t.setRunning(true)
 
 	t.logCallback(0, "[Start] State set to connecting")
 	t.notifyState(StateConnecting, "Starting tunnel connection...")
 
-	// Parse the token
-	t.logCallback(0, "[Start] Parsing token...")
-	token, err := parseToken(t.config.Token)
-	if err != nil {
-		t.logCallback(2, "[Start] Token parse error: %v", err)
-		t.setError(err)
-		return err
+	// Don't overlap the replaced global tunnel, which may still hold the metrics port
+	if t.previousRun != nil {
+		select {
+		case <-t.previousRun:
+		case <-ctx.Done():
+		case <-time.After(forcedStopTimeout):
+			t.logCallback(1, "[Start] Previous tunnel still stopping, starting anyway")
+		}
+		if t.stopRequested() {
+			return nil
+		}
 	}
-	t.logCallback(0, "[Start] Token parsed successfully, TunnelID: %s", token.TunnelID)
 
-	credentials := token.Credentials()
-	t.logCallback(0, "[Start] Got credentials, AccountTag: %s", credentials.AccountTag)
+	stopConnectTimer := t.startConnectTimer(connected)
+	defer stopConnectTimer()
 
-	// Create tunnel properties
-	namedTunnel := &connection.TunnelProperties{
-		Credentials: credentials,
+	// Setters called while the tunnel runs take effect on the next start
+	cfg := t.configSnapshot()
+
+	var namedTunnel *connection.TunnelProperties
+	if cfg.QuickTunnel {
+		// Request an ephemeral tunnel from the quick tunnel service
+		t.logCallback(0, "[Start] Requesting quick tunnel...")
+		namedTunnel, err = t.requestQuickTunnel()
+		if timeoutErr := t.connectTimeoutError(); timeoutErr != nil {
+			err = timeoutErr
+		}
+		if err != nil {
+			t.logCallback(2, "[Start] Quick tunnel error: %v", err)
+			t.setError(err)
+			return err
+		}
+		if t.stopRequested() {
+			return nil
+		}
+	} else {
+		// Parse the token
+		t.logCallback(0, "[Start] Parsing token...")
+		token, err := parseToken(cfg.Token)
+		if err != nil {
+			t.logCallback(2, "[Start] Token parse error: %v", err)
+			t.setError(err)
+			return err
+		}
+		t.logCallback(0, "[Start] Token parsed successfully, TunnelID: %s", token.TunnelID)
+
+		credentials := token.Credentials()
+		t.logCallback(0, "[Start] Got credentials, AccountTag: %s", credentials.AccountTag)
+
+		// Create tunnel properties
+		namedTunnel = &connection.TunnelProperties{
+			Credentials: credentials,
+		}
 	}
 	t.logCallback(0, "[Start] Created tunnel properties")
 
 	// Run the tunnel
 	t.logCallback(0, "[Start] Calling runTunnel...")
-	err = t.runTunnel(namedTunnel)
+	err = t.runTunnel(cfg, namedTunnel)
+	if timeoutErr := t.connectTimeoutError(); timeoutErr != nil {
+		t.logCallback(2, "[Start] %v", timeoutErr)
+		t.cachedEdgeFailed()
+		t.setError(timeoutErr)
+		return timeoutErr
+	}
 	if err != nil {
 		t.logCallback(2, "[Start] runTunnel returned error: %v", err)
+		// Errors after Stop are part of the shutdown, not a failure
+		if !t.stopRequested() {
+			t.setError(err)
+		}
 	}
 
-	t.notifyState(StateDisconnected, "Tunnel stopped")
-
 	return err
 }
 
+// configSnapshot returns a copy of the tunnel config for one run
+func (t *Tunnel) configSnapshot() *TunnelConfig {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	cfg := *t.config
+	cfg.IngressRules = slices.Clone(cfg.IngressRules)
+	cfg.EdgeAddrs = slices.Clone(cfg.EdgeAddrs)
+	cfg.Tags = maps.Clone(cfg.Tags)
+	return &cfg
+}
+
+// startConnectTimer cancels the run if the tunnel has not connected within
+// ConnectTimeoutMs. The returned function stops the timer.
+func (t *Tunnel) startConnectTimer(connected chan struct{}) (stop func()) {
+	t.mu.RLock()
+	timeout := time.Duration(t.config.ConnectTimeoutMs) * time.Millisecond
+	t.mu.RUnlock()
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		select {
+		case <-connected:
+			return
+		default:
+		}
+		t.mu.Lock()
+		t.connectTimedOut = true
+		cancel := t.cancel
+		t.mu.Unlock()
+
+		t.logCallback(2, "[Start] No edge connection after %s, giving up", timeout)
+		if cancel != nil {
+			cancel()
+		}
+	})
+	return func() { timer.Stop() }
+}
+
+// connectTimeoutError returns the error for a run cancelled by the connect timeout, or nil
+func (t *Tunnel) connectTimeoutError() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if !t.connectTimedOut {
+		return nil
+	}
+	timeout := time.Duration(t.config.ConnectTimeoutMs) * time.Millisecond
+	return newTunnelError(ErrCodeConnectTimeout, fmt.Errorf("no edge connection established within %s", timeout))
+}
+
 // logCallback is a helper to log messages via callback
 func (t *Tunnel) logCallback(level int, format string, args ...interface{}) {
-	logToCallback(t.callback, level, format, args...)
+	zlevel := wrapperLevel(level)
+	t.emitLogEvent(zlevel, LogEvent{
+		Source:  LogSourceTunnel,
+		Level:   zlevel.String(),
+		Time:    time.Now().Format(time.RFC3339),
+		Message: fmt.Sprintf(format, args...),
+		Fields:  map[string]interface{}{"component": "mobile"},
+	})
 }
 
-func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
+func (t *Tunnel) runTunnel(cfg *TunnelConfig, namedTunnel *connection.TunnelProperties) error {
 	ctx := t.ctx
 	log := t.log
 
@@ -413,15 +655,51 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 
 	if namedTunnel.Credentials.AccountTag == "" {
 		t.logCallback(2, "[runTunnel] ERROR: account tag is empty")
-		return errors.New("account tag is empty")
+		return newTunnelError(ErrCodeInvalidToken, errors.New("account tag is empty"))
 	}
 	t.logCallback(0, "[runTunnel] AccountTag: %s", namedTunnel.Credentials.AccountTag)
 
+	// Register this run's collectors with the tunnel's registry, and take them
+	// out again when it ends so the next run can register its own
+	registerer := newRunRegisterer(t.registry, namedTunnel.Credentials.TunnelID.String())
+	defer registerer.unregisterAll()
+	registerer.MustRegister(t.metrics.collectors()...)
+
 	t.logCallback(0, "[runTunnel] Creating feature selector...")
 	t.notifyState(StateConnecting, "Creating feature selector...")
 
-	// Create feature selector
-	featureSelector, err := features.NewFeatureSelector(ctx, namedTunnel.Credentials.AccountTag, nil, t.config.EnablePostQuantum, log)
+	resolver := t.resolver()
+	defer resolver.Close()
+
+	region := namedTunnel.Credentials.Endpoint
+	if cfg.Region != "" {
+		region = cfg.Region
+	}
+	configuredIPVersion, err := parseEdgeIPVersion(cfg.EdgeIPVersion)
+	if err != nil {
+		return err
+	}
+	bindIP, err := resolveBindAddress(cfg.BindAddress, configuredIPVersion)
+	if err != nil {
+		return err
+	}
+	ipVersion := bindIPVersion(bindIP, configuredIPVersion)
+	if bindIP != nil {
+		t.logCallback(0, "[runTunnel] Binding edge connections to %s", bindIP)
+	}
+	t.mu.Lock()
+	t.bindIP = bindIP
+	t.mu.Unlock()
+
+	var cache *edgeCache
+	if len(cfg.EdgeAddrs) == 0 {
+		cache = t.loadEdgeCache(region)
+	}
+
+	// Create feature selector. Its own TXT lookup can only use the system resolver,
+	// so the rollout is read through the tunnel's resolver and passed in.
+	cliFeatures := t.lookupFeatures(ctx, resolver, namedTunnel.Credentials.AccountTag)
+	featureSelector, err := features.NewFeatureSelector(ctx, namedTunnel.Credentials.AccountTag, cliFeatures, cfg.EnablePostQuantum, log)
 	if err != nil {
 		t.logCallback(2, "[runTunnel] ERROR creating feature selector: %v", err)
 		return fmt.Errorf("failed to create feature selector: %w", err)
@@ -436,7 +714,7 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 	t.notifyState(StateConnecting, "Creating client config...")
 
 	// Create client config
-	clientConfig, err := client.NewConfig(Version, "mobile", featureSelector)
+	clientConfig, err := client.NewConfig(Version, connectorArch, featureSelector)
 	if err != nil {
 		t.logCallback(2, "[runTunnel] ERROR creating client config: %v", err)
 		return fmt.Errorf("failed to create client config: %w", err)
@@ -445,35 +723,35 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 		t.logCallback(2, "[runTunnel] ERROR: client config is nil")
 		return errors.New("client config is nil")
 	}
+	clientConfig.ConnectorID = t.connectorIDFor(namedTunnel.Credentials.TunnelID, clientConfig.ConnectorID)
 	t.logCallback(0, "[runTunnel] Client config created, ConnectorID: %s", clientConfig.ConnectorID)
 
-	log.Info().Msgf("Generated Connector ID: %s", clientConfig.ConnectorID)
+	log.Info().Msgf("Connector ID: %s", clientConfig.ConnectorID)
 
 	// Create tags
-	tags := []pogs.Tag{
-		{Name: "ID", Value: clientConfig.ConnectorID.String()},
-		{Name: "platform", Value: "mobile"},
-	}
-	t.logCallback(0, "[runTunnel] Tags created")
+	tags := connectorTags(clientConfig.ConnectorID.String(), cfg.Tags)
+	t.mu.Lock()
+	t.connectorID = clientConfig.ConnectorID.String()
+	t.tunnelID = namedTunnel.Credentials.TunnelID.String()
+	t.accountTag = namedTunnel.Credentials.AccountTag
+	t.tags = tags
+	t.mu.Unlock()
+	t.logCallback(0, "[runTunnel] Tags created: %d", len(tags))
 
 	t.logCallback(0, "[runTunnel] Creating protocol selector...")
 	t.notifyState(StateConnecting, "Creating protocol selector...")
 
-	// Determine protocol - use a simpler approach that doesn't require DNS lookup
-	protocolSelector, err := connection.NewProtocolSelector(
-		connection.QUIC.String(), // Force QUIC protocol instead of auto-select
-		namedTunnel.Credentials.AccountTag,
-		true, // hasToken
-		t.config.EnablePostQuantum,
-		func() (edgediscovery.ProtocolPercents, error) {
-			// Return default protocol percentages to avoid DNS lookup issues on mobile
-			return edgediscovery.ProtocolPercents{
-				{Protocol: "quic", Percentage: 100},
-			}, nil
-		},
-		connection.ResolveTTL,
-		log,
-	)
+	protocol, err := parseProtocol(cfg.Protocol, cfg.EnablePostQuantum)
+	if err != nil {
+		return err
+	}
+	if protocol == ProtocolAuto && cache != nil && cache.Protocol == ProtocolHTTP2 {
+		// QUIC didn't work last time; skip the QUIC attempts until the cache expires
+		t.logCallback(0, "[runTunnel] Using cached protocol: %s", cache.Protocol)
+		protocol = ProtocolHTTP2
+	}
+
+	protocolSelector, err := newProtocolSelector(protocol, namedTunnel.Credentials.AccountTag, !cfg.QuickTunnel, cfg.EnablePostQuantum, log)
 	if err != nil {
 		t.logCallback(2, "[runTunnel] ERROR creating protocol selector: %v", err)
 		return fmt.Errorf("failed to create protocol selector: %w", err)
@@ -484,14 +762,21 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 	}
 	t.logCallback(0, "[runTunnel] Protocol selector created, current: %s", protocolSelector.Current())
 
-	log.Info().Msgf("Initial protocol: %s", protocolSelector.Current())
-	t.notifyState(StateConnecting, fmt.Sprintf("Using protocol: %s", protocolSelector.Current()))
+	log.Info().Msgf("Initial protocol: %s (%s)", protocolSelector.Current(), protocol)
+	if fallback, ok := protocolSelector.Fallback(); ok {
+		t.notifyState(StateConnecting, fmt.Sprintf("Using protocol: %s (fallback: %s)", protocolSelector.Current(), fallback))
+	} else {
+		t.notifyState(StateConnecting, fmt.Sprintf("Using protocol: %s", protocolSelector.Current()))
+	}
 
 	// Create TLS configs with embedded root CAs for mobile
 	t.logCallback(0, "[runTunnel] Creating TLS configs...")
 	t.notifyState(StateConnecting, "Creating TLS configs...")
 	edgeTLSConfigs := make(map[connection.Protocol]*tls.Config)
-	mobileRootCAs := getMobileRootCAs()
+	mobileRootCAs := t.edgeRootCAs
+	if mobileRootCAs == nil {
+		mobileRootCAs = getMobileRootCAs()
+	}
 	t.logCallback(0, "[runTunnel] Loaded mobile root CAs")
 	for _, p := range connection.ProtocolList {
 		tlsSettings := p.TLSSettings()
@@ -509,11 +794,25 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 	t.logCallback(0, "[runTunnel] TLS configs created, count: %d", len(edgeTLSConfigs))
 
 	// Create ingress rules
-	// For remotely-managed tunnels (token-based), the ingress configuration
-	// is fetched from the Cloudflare dashboard. We start with an empty ingress
-	// and let the orchestrator update it from remote config.
-	ingressRules := ingress.Ingress{}
-	t.logCallback(0, "[runTunnel] Empty ingress rules created (will be fetched from dashboard)")
+	// If an origin URL or ingress rules were given, they are compiled into a
+	// locally-managed ingress. Otherwise we start with an empty ingress and wait
+	// for the dashboard configuration. In both cases the orchestrator starts at
+	// version -1, so a remote config pushed by the edge still overrides it.
+	ingressRules, configSource, err := buildIngress(cfg)
+	if err != nil {
+		t.logCallback(2, "[runTunnel] ERROR building ingress: %v", err)
+		return err
+	}
+	t.mu.Lock()
+	t.configSource = configSource
+	t.mu.Unlock()
+	if configSource == ConfigSourceLocal {
+		t.logCallback(0, "[runTunnel] Local ingress created, rules: %d", len(ingressRules.Rules))
+		t.notifyState(StateConnecting, fmt.Sprintf("Using local ingress (%d rules)", len(ingressRules.Rules)))
+	} else {
+		t.logCallback(0, "[runTunnel] Empty ingress rules created (will be fetched from dashboard)")
+		t.notifyState(StateConnecting, "Waiting for remote ingress configuration from dashboard")
+	}
 
 	t.logCallback(0, "[runTunnel] Creating origin services...")
 	t.notifyState(StateConnecting, "Creating origin services...")
@@ -554,7 +853,7 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 	t.logCallback(0, "[runTunnel] DNS dialer created OK")
 
 	t.logCallback(0, "[runTunnel] Creating DNS service...")
-	dnsService := origins.NewDNSResolverService(dnsDialer, log, nil)
+	dnsService := origins.NewDNSResolverService(dnsDialer, log, origins.NewMetrics(registerer))
 	if dnsService == nil {
 		t.logCallback(2, "[runTunnel] ERROR: DNS service is nil")
 		return errors.New("DNS service is nil")
@@ -573,47 +872,95 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 		t.logCallback(2, "[runTunnel] ERROR: observer is nil")
 		return errors.New("observer is nil")
 	}
+	observer.RegisterSink(connection.EventSinkFunc(t.onTunnelEvent))
 	t.logCallback(0, "[runTunnel] Observer created OK")
 
+	// Start the optional loopback metrics listener
+	if cfg.MetricsAddress != "" {
+		t.logCallback(0, "[runTunnel] Starting metrics server on %s...", cfg.MetricsAddress)
+		stopMetricsServer, err := t.startMetricsServer(cfg.MetricsAddress)
+		if err != nil {
+			t.logCallback(2, "[runTunnel] ERROR starting metrics server: %v", err)
+			return err
+		}
+		defer stopMetricsServer()
+	}
+
 	// HA connections
-	haConnections := t.config.HAConnections
+	haConnections := cfg.HAConnections
 	if haConnections < 1 {
 		haConnections = 4
 	}
 	t.logCallback(0, "[runTunnel] HA connections: %d", haConnections)
 
+	// Use the configured or cached edge addresses, or resolve the edge with the tunnel's
+	// resolver. If that fails, cloudflared falls back to its own discovery (SRV lookup,
+	// then DNS over TLS).
+	var edgeAddrs []string
+	if len(cfg.EdgeAddrs) > 0 {
+		edgeAddrs, err = resolver.resolveStaticEdgeAddrs(ctx, cfg.EdgeAddrs)
+		if err != nil {
+			t.logCallback(2, "[runTunnel] ERROR resolving configured edge addresses: %v", err)
+			return fmt.Errorf("failed to resolve edge addresses: %w", err)
+		}
+		t.logCallback(0, "[runTunnel] Using %d configured edge addresses", len(edgeAddrs))
+		t.notifyState(StateConnecting, "Using configured edge addresses...")
+	} else if cache != nil {
+		edgeAddrs = cache.addrs()
+		t.mu.Lock()
+		t.edgeCache = cache
+		t.usedEdgeCache = true
+		t.mu.Unlock()
+		t.logCallback(0, "[runTunnel] Using %d cached edge addresses from %s", len(edgeAddrs), cache.UpdatedAt.Format(time.RFC3339))
+		t.notifyState(StateConnecting, "Using cached edge addresses...")
+	} else {
+		edgeAddrs = t.discoverEdgeAddrs(ctx, resolver, region)
+	}
+	discoveredEdgeAddrs := edgeAddrs
+	if edgeAddrs != nil {
+		edgeAddrs = filterEdgeAddrs(edgeAddrs, ipVersion)
+		if len(edgeAddrs) == 0 && len(cfg.EdgeAddrs) > 0 {
+			t.logCallback(2, "[runTunnel] ERROR: no edge address matches IP version %s", cfg.EdgeIPVersion)
+			return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("no edge address matches IP version %s", cfg.EdgeIPVersion))
+		}
+		if len(edgeAddrs) == 0 {
+			t.logCallback(1, "[runTunnel] No discovered edge address matches IP version %s, using cloudflared's discovery", cfg.EdgeIPVersion)
+			edgeAddrs = nil
+		}
+	}
+
 	t.logCallback(0, "[runTunnel] Creating tunnel config...")
 	t.notifyState(StateConnecting, "Creating tunnel config...")
 
 	// Create tunnel config
 	tunnelConfig := &supervisor.TunnelConfig{
-		ClientConfig:     clientConfig,
-		GracePeriod:      30 * time.Second,
-		EdgeAddrs:        nil,
-		Region:           namedTunnel.Credentials.Endpoint,
-		EdgeIPVersion:    allregions.Auto,
-		EdgeBindAddr:     nil,
-		HAConnections:    haConnections,
-		IsAutoupdated:    false,
-		LBPool:           "",
-		Tags:             tags,
-		Log:              log,
-		LogTransport:     log,
-		Observer:         observer,
-		ReportedVersion:  Version,
-		Retries:          5,
-		RunFromTerminal:  false,
-		NamedTunnel:      namedTunnel,
-		ProtocolSelector: protocolSelector,
-		EdgeTLSConfigs:   edgeTLSConfigs,
-		MaxEdgeAddrRetries: 8,
-		RPCTimeout:       5 * time.Second,
-		WriteStreamTimeout: 0,
-		DisableQUICPathMTUDiscovery: false,
+		ClientConfig:                        clientConfig,
+		GracePeriod:                         durationMs(cfg.GracePeriodMs, defaultGracePeriod),
+		EdgeAddrs:                           edgeAddrs,
+		Region:                              region,
+		EdgeIPVersion:                       ipVersion,
+		EdgeBindAddr:                        bindIP,
+		HAConnections:                       haConnections,
+		IsAutoupdated:                       false,
+		LBPool:                              "",
+		Tags:                                tags,
+		Log:                                 log,
+		LogTransport:                        log,
+		Observer:                            observer,
+		ReportedVersion:                     Version,
+		Retries:                             uint(intOrDefault(cfg.Retries, defaultRetries)),
+		RunFromTerminal:                     false,
+		NamedTunnel:                         namedTunnel,
+		ProtocolSelector:                    protocolSelector,
+		EdgeTLSConfigs:                      edgeTLSConfigs,
+		MaxEdgeAddrRetries:                  uint8(intOrDefault(cfg.MaxEdgeAddrRetries, defaultMaxEdgeAddrRetries)),
+		RPCTimeout:                          durationMs(cfg.RPCTimeoutMs, defaultRPCTimeout),
+		WriteStreamTimeout:                  0,
+		DisableQUICPathMTUDiscovery:         false,
 		QUICConnectionLevelFlowControlLimit: 30 * (1 << 20),
 		QUICStreamLevelFlowControlLimit:     6 * (1 << 20),
-		OriginDNSService:     dnsService,
-		OriginDialerService:  originDialerService,
+		OriginDNSService:                    dnsService,
+		OriginDialerService:                 originDialerService,
 	}
 
 	t.logCallback(0, "[runTunnel] Tunnel config created OK")
@@ -645,29 +992,111 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 	t.logCallback(0, "[runTunnel] Starting tunnel daemon...")
 	t.notifyState(StateConnecting, "Starting tunnel daemon...")
 
-	// Create reconnect channel
+	// Create reconnect channel, used by NotifyNetworkChanged
 	reconnectCh := make(chan supervisor.ReconnectSignal, haConnections)
+	run := &daemonRun{
+		config:     tunnelConfig,
+		edgeAddrs:  discoveredEdgeAddrs,
+		configured: len(cfg.EdgeAddrs) > 0,
+		ipVersion:  configuredIPVersion,
+	}
+	// Cached edges get a single short attempt before the edge is rediscovered,
+	// instead of the supervisor's full retry budget
+	cachedAttempt := len(cfg.EdgeAddrs) == 0 && cache != nil
+	retries := tunnelConfig.Retries
+	if cachedAttempt {
+		run.config = run.withRetries(min(retries, 1))
+	}
+	t.mu.Lock()
+	t.reconnectCh = reconnectCh
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.daemonRun = nil
+		t.reconnectCh = nil
+		t.mu.Unlock()
+		t.cancelReconnect()
+	}()
 	t.logCallback(0, "[runTunnel] Reconnect channel created")
 
 	// Create connected signal
 	connectedSignal := signal.New(make(chan struct{}))
 	t.logCallback(0, "[runTunnel] Connected signal created")
 
-	// Watch for connection
-	go func() {
+	// Watch for connection until the daemon returns
+	daemonDone := make(chan struct{})
+	defer close(daemonDone)
+	t.goTracked(func() { t.watchRemoteConfig(orchestrator, daemonDone) })
+	t.goTracked(func() {
 		t.logCallback(0, "[runTunnel] Waiting for connected signal...")
-		<-connectedSignal.Wait()
+		select {
+		case <-connectedSignal.Wait():
+		case <-daemonDone:
+			return
+		}
 		t.logCallback(0, "[runTunnel] Connected signal received!")
+		if protocol := t.GetProtocol(); protocol != "" {
+			t.setState(StateConnected, fmt.Sprintf("Tunnel connected successfully via %s", protocol))
+		} else {
+			t.setState(StateConnected, "Tunnel connected successfully")
+		}
+	})
+
+	// Start the tunnel daemon. Rebind cancels it and queues a config with the new
+	// bind address, and cached edges that fail are replaced by rediscovered ones;
+	// the daemon is then started again with the same orchestrator, observer and
+	// metrics registry.
+	for {
+		daemonCtx, cancelDaemon := context.WithCancel(ctx)
 		t.mu.Lock()
-		t.state = StateConnected
-		t.connectedAt = time.Now()
+		run.cancel = cancelDaemon
+		t.daemonRun = run
+		daemonConfig := run.config
 		t.mu.Unlock()
-		t.notifyState(StateConnected, "Tunnel connected successfully")
-	}()
 
-	// Start the tunnel daemon
-	t.logCallback(0, "[runTunnel] Calling StartTunnelDaemon...")
-	err = supervisor.StartTunnelDaemon(ctx, tunnelConfig, orchestrator, connectedSignal, reconnectCh, t.graceShutdownC)
+		stopCachedAttempt := func() bool { return false }
+		if cachedAttempt {
+			stopCachedAttempt = time.AfterFunc(cachedEdgeTimeout(daemonConfig.RPCTimeout), func() {
+				select {
+				case <-connectedSignal.Wait():
+				default:
+					t.logCallback(1, "[runTunnel] Cached edge addresses did not connect in time")
+					cancelDaemon()
+				}
+			}).Stop
+		}
+
+		t.logCallback(0, "[runTunnel] Calling StartTunnelDaemon...")
+		err = t.daemon(daemonCtx, daemonConfig, orchestrator, connectedSignal, reconnectCh, t.graceShutdownC)
+		cancelDaemon()
+		stopCachedAttempt()
+
+		t.mu.Lock()
+		next := run.next
+		run.next = nil
+		if next != nil {
+			if cachedAttempt {
+				next.Retries = retries
+			}
+			run.config = next
+		}
+		t.mu.Unlock()
+		if cachedAttempt && next == nil && ctx.Err() == nil && !t.stopRequested() && t.cachedEdgeFailed() {
+			t.logCallback(1, "[runTunnel] Cached edge addresses failed (%v), rediscovering", err)
+			t.notifyState(StateConnecting, "Cached edge addresses failed, rediscovering...")
+			addrs := t.discoverEdgeAddrs(ctx, resolver, region)
+			t.mu.Lock()
+			next = run.withEdgeAddrs(addrs, retries)
+			run.config = next
+			t.usedEdgeCache = false
+			t.mu.Unlock()
+		}
+		cachedAttempt = false
+		if next == nil || ctx.Err() != nil || t.stopRequested() {
+			break
+		}
+		t.logCallback(0, "[runTunnel] Restarting tunnel daemon bound to %s", bindAddressString(next.EdgeBindAddr))
+	}
 	if err != nil {
 		t.logCallback(2, "[runTunnel] ERROR from StartTunnelDaemon: %v", err)
 		return fmt.Errorf("tunnel daemon error: %w", err)
@@ -677,18 +1106,13 @@ func (t *Tunnel) runTunnel(namedTunnel *connection.TunnelProperties) error {
 	return nil
 }
 
-// Stop gracefully stops the tunnel
+// Stop signals the tunnel to stop without waiting for it. The state changes to
+// disconnected once the run has returned. Use StopWithTimeout to drain
+// connections and wait for the shutdown to complete.
 func (t *Tunnel) Stop() {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	if t.cancel != nil {
-		// Signal graceful shutdown
-		close(t.graceShutdownC)
-		t.cancel()
-		t.cancel = nil
+	if t.signalStop(true) == nil {
+		t.stopIdle()
 	}
-	t.state = StateDisconnected
 }
 
 // GetState returns the current tunnel state
@@ -715,12 +1139,11 @@ func (t *Tunnel) IsConnected() bool {
 func (t *Tunnel) setError(err error) {
 	t.mu.Lock()
 	t.lastError = err
-	t.state = StateError
 	t.mu.Unlock()
 
-	t.notifyState(StateError, err.Error())
+	t.setState(StateError, err.Error())
 	if t.callback != nil {
-		t.callback.OnError(1, err.Error())
+		t.callback.OnError(int(classifyError(err)), err.Error())
 	}
 }
 
@@ -744,7 +1167,7 @@ func StartTunnel(token string, originURL string) error {
 	tunnelMu.Lock()
 	if globalTunnel != nil {
 		tunnelMu.Unlock()
-		return errors.New("tunnel is already running")
+		return newTunnelError(ErrCodeAlreadyRunning, errors.New("tunnel is already running"))
 	}
 
 	tunnel, err := NewTunnel(token, originURL, nil)
@@ -760,60 +1183,100 @@ func StartTunnel(token string, originURL string) error {
 
 // StartTunnelWithCallback starts a tunnel with a callback for state updates.
 // This blocks until the tunnel is stopped or encounters an error.
-func StartTunnelWithCallback(token string, originURL string, callback TunnelCallback) (err error) {
-	// Recover from any panics in the Go code, including duplicate metrics registration
+func StartTunnelWithCallback(token string, originURL string, callback TunnelCallback) error {
+	return startGlobalTunnel(func() (*Tunnel, error) {
+		return NewTunnel(token, originURL, callback)
+	}, callback)
+}
+
+// StartTunnelWithIngress starts a tunnel with a locally-managed ingress made of
+// ingressJSON (a JSON array of {"hostname", "path", "service"} rules) followed by
+// originURL as the catch-all.
+// This blocks until the tunnel is stopped or encounters an error.
+func StartTunnelWithIngress(token string, originURL string, ingressJSON string, callback TunnelCallback) error {
+	rules, err := parseIngressRulesJSON(ingressJSON)
+	if err != nil {
+		return err
+	}
+	return startGlobalTunnel(func() (*Tunnel, error) {
+		tunnel, err := NewTunnel(token, originURL, callback)
+		if err != nil {
+			return nil, err
+		}
+		tunnel.config.IngressRules = rules
+		return tunnel, nil
+	}, callback)
+}
+
+// startGlobalTunnel replaces the global tunnel with the one built by create and runs it
+func startGlobalTunnel(create func() (*Tunnel, error), callback TunnelCallback) error {
+	tunnel, err := replaceGlobalTunnel(create, callback)
+	if err != nil {
+		return err
+	}
+	return tunnel.Start()
+}
+
+// replaceGlobalTunnel stops the global tunnel and installs the one built by create,
+// with the settings made through the static API
+func replaceGlobalTunnel(create func() (*Tunnel, error), callback TunnelCallback) (tunnel *Tunnel, err error) {
+	// Recover from any panics in the Go code
 	defer func() {
 		if r := recover(); r != nil {
-			errStr := fmt.Sprintf("%v", r)
-			// Check if this is a duplicate metrics error - if so, we need to inform user to restart app
-			if contains(errStr, "duplicate metrics") || contains(errStr, "already registered") {
-				err = fmt.Errorf("metrics already registered - please restart the app completely to start tunnel again")
-			} else {
-				err = fmt.Errorf("tunnel panic: %v", r)
-			}
+			err = newTunnelError(ErrCodePanic, fmt.Errorf("tunnel panic: %v", r))
 			if callback != nil {
-				callback.OnError(1, err.Error())
+				callback.OnError(int(ErrCodePanic), err.Error())
 			}
 		}
 	}()
 
-	// Cleanup any existing tunnel state
-	cleanupMetricsState()
-
+	// Stop the existing tunnel first. Its shutdown finishes in the background so
+	// callers on the app's main thread never wait for it.
 	tunnelMu.Lock()
-	if globalTunnel != nil {
-		// Stop existing tunnel first
-		globalTunnel.Stop()
-		globalTunnel = nil
-		// Give some time for cleanup
-		time.Sleep(100 * time.Millisecond)
-	}
+	previous := globalTunnel
+	globalTunnel = nil
 	tunnelMu.Unlock()
+	var previousRun <-chan struct{}
+	if previous != nil {
+		previousRun = previous.stopInBackground()
+	}
 
 	tunnelMu.Lock()
-	tunnel, err := NewTunnel(token, originURL, callback)
+	tunnel, err = create()
 	if err != nil {
 		tunnelMu.Unlock()
-		return err
+		return nil, err
+	}
+	connectionCallbackMu.Lock()
+	tunnel.connectionCallback = globalConnectionCallback
+	connectionCallbackMu.Unlock()
+	logSettingsMu.Lock()
+	tunnel.logEventCallback = globalLogEventCallback
+	if tunnel.config.LogLevel == "" {
+		_ = tunnel.SetLogLevel(globalLogLevel)
+	}
+	logSettingsMu.Unlock()
+	resolverSettingsMu.Lock()
+	if tunnel.config.Resolver == nil {
+		tunnel.config.Resolver = globalResolverConfig
 	}
+	resolverSettingsMu.Unlock()
+	cacheDirMu.Lock()
+	if tunnel.config.CacheDir == "" {
+		tunnel.config.CacheDir = globalCacheDir
+	}
+	globalCacheTunnelID = tunnel.cacheTunnelID()
+	cacheDirMu.Unlock()
+	connectorTagsMu.Lock()
+	if len(tunnel.config.Tags) == 0 {
+		tunnel.config.Tags = globalConnectorTags
+	}
+	connectorTagsMu.Unlock()
+	tunnel.previousRun = previousRun
 	globalTunnel = tunnel
 	tunnelMu.Unlock()
 
-	return tunnel.Start()
-}
-
-// contains checks if a string contains a substring (case-insensitive)
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
-}
-
-func containsHelper(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
+	return tunnel, nil
 }
 
 // StopTunnel stops the currently running tunnel
@@ -825,9 +1288,6 @@ func StopTunnel() {
 		globalTunnel.Stop()
 		globalTunnel = nil
 	}
-
-	// Reset the Prometheus registry so next start won't have duplicate metrics
-	resetPrometheusRegistry()
 }
 
 // IsTunnelRunning returns true if a tunnel is currently running
@@ -857,13 +1317,29 @@ func GetTunnelStateString() string {
 	return globalTunnel.GetStateString()
 }
 
-// ValidateToken checks if a token is valid without starting a tunnel
+// GetTunnelConfigSource returns where the running tunnel's ingress rules come from
+// ("local" or "remote"), or an empty string if no tunnel is running
+func GetTunnelConfigSource() string {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel == nil {
+		return ""
+	}
+	return globalTunnel.GetConfigSource()
+}
+
+// ValidateToken checks if a token is valid without starting a tunnel and returns
+// its metadata as JSON (tunnelId, accountTag, endpoint, region). The secret is not included.
 func ValidateToken(token string) (string, error) {
-	parsed, err := parseToken(token)
+	info, err := tokenInfo(token)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(info)
 	if err != nil {
 		return "", err
 	}
-	return parsed.TunnelID.String(), nil
+	return string(data), nil
 }
 
 // GetVersion returns the version of the mobile library
@@ -871,19 +1347,16 @@ func GetVersion() string {
 	return Version
 }
 
-// ForceReset performs a complete reset of all tunnel state and metrics.
+// ForceReset performs a complete reset of all tunnel state.
 // This should be called when you want to completely restart from scratch.
+// It returns immediately; the old tunnel finishes shutting down in the background.
 func ForceReset() {
 	tunnelMu.Lock()
-	if globalTunnel != nil {
-		globalTunnel.Stop()
-		globalTunnel = nil
-	}
+	tunnel := globalTunnel
+	globalTunnel = nil
 	tunnelMu.Unlock()
 
-	// Reset Prometheus registry
-	resetPrometheusRegistry()
-
-	// Give some time for goroutines to clean up
-	time.Sleep(200 * time.Millisecond)
+	if tunnel != nil {
+		tunnel.stopInBackground()
+	}
 }
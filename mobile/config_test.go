@@ -0,0 +1,65 @@
+package mobile
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAndTimeoutSettingsReachSupervisor(t *testing.T) {
+	token := testToken(t)
+	tests := []struct {
+		json               string
+		retries            uint
+		maxEdgeAddrRetries uint8
+		gracePeriod        time.Duration
+		rpcTimeout         time.Duration
+	}{
+		{`{"token":"` + token + `"}`, defaultRetries, defaultMaxEdgeAddrRetries, defaultGracePeriod, defaultRPCTimeout},
+		{`{"token":"` + token + `","retries":-1,"maxEdgeAddrRetries":-1,"gracePeriodMs":0,"rpcTimeoutMs":0}`,
+			defaultRetries, defaultMaxEdgeAddrRetries, defaultGracePeriod, defaultRPCTimeout},
+		{`{"token":"` + token + `","retries":0,"maxEdgeAddrRetries":0,"gracePeriodMs":1500,"rpcTimeoutMs":250}`,
+			0, 0, 1500 * time.Millisecond, 250 * time.Millisecond},
+		{`{"token":"` + token + `","retries":3,"maxEdgeAddrRetries":255}`, 3, 255, defaultGracePeriod, defaultRPCTimeout},
+	}
+	for _, tt := range tests {
+		config, err := parseTunnelConfig(tt.json)
+		if err != nil {
+			t.Fatalf("parseTunnelConfig(%s): %v", tt.json, err)
+		}
+		edge := newFakeEdge(t)
+		tunnel := newFakeEdgeTunnel(t, edge, config, nil)
+		startConnected(t, tunnel)
+		if _, err := tunnel.StopWithTimeout(0); err != nil {
+			t.Fatal(err)
+		}
+
+		configs := edge.getDaemonConfigs()
+		if len(configs) != 1 {
+			t.Fatalf("%s: %d daemons started, want 1", tt.json, len(configs))
+		}
+		got := configs[0]
+		if got.Retries != tt.retries || got.MaxEdgeAddrRetries != tt.maxEdgeAddrRetries {
+			t.Errorf("%s: retries = %d, maxEdgeAddrRetries = %d; want %d and %d",
+				tt.json, got.Retries, got.MaxEdgeAddrRetries, tt.retries, tt.maxEdgeAddrRetries)
+		}
+		if got.GracePeriod != tt.gracePeriod || got.RPCTimeout != tt.rpcTimeout {
+			t.Errorf("%s: gracePeriod = %s, rpcTimeout = %s; want %s and %s",
+				tt.json, got.GracePeriod, got.RPCTimeout, tt.gracePeriod, tt.rpcTimeout)
+		}
+	}
+}
+
+func TestParseTunnelConfigRejectsInvalidRetriesAndTimeouts(t *testing.T) {
+	token := testToken(t)
+	for _, settings := range []string{
+		`"maxEdgeAddrRetries":-2`,
+		`"maxEdgeAddrRetries":256`,
+		`"gracePeriodMs":-1`,
+		`"rpcTimeoutMs":-1`,
+		`"connectTimeoutMs":-1`,
+	} {
+		if _, err := parseTunnelConfig(`{"token":"` + token + `",` + settings + `}`); classifyError(err) != ErrCodeInvalidConfig {
+			t.Errorf("%s: error = %v, want InvalidConfig", settings, err)
+		}
+	}
+}
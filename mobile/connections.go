@@ -0,0 +1,245 @@
+package mobile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// Connection event types reported by OnConnectionEvent
+const (
+	ConnEventConnected     = "connected"
+	ConnEventDisconnected  = "disconnected"
+	ConnEventReconnecting  = "reconnecting"
+	ConnEventRegistering   = "registering"
+	ConnEventUnregistering = "unregistering"
+	ConnEventSetURL        = "set_url"
+)
+
+// ConnectionEventCallback receives events for individual HA connections
+type ConnectionEventCallback interface {
+	OnConnectionEvent(index int, eventType string, location string, protocol string, edgeIP string)
+}
+
+// ConnectionInfo describes one HA connection to the edge
+type ConnectionInfo struct {
+	Index       int    `json:"index"`
+	State       string `json:"state"`
+	Location    string `json:"location"`
+	Protocol    string `json:"protocol"`
+	EdgeIP      string `json:"edgeIp"`
+	ConnectedAt string `json:"connectedAt,omitempty"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// ConnectionsStatus is the JSON returned by GetConnections
+type ConnectionsStatus struct {
+	HAConnections     int              `json:"haConnections"`
+	ActiveConnections int              `json:"activeConnections"`
+	Protocol          string           `json:"protocol"`
+	Locations         []string         `json:"locations"`
+	Connections       []ConnectionInfo `json:"connections"`
+}
+
+var (
+	// globalConnectionCallback is applied to tunnels started by the static API
+	globalConnectionCallback ConnectionEventCallback
+	connectionCallbackMu     sync.Mutex
+)
+
+// connEventType maps a cloudflared connection status to an event type
+func connEventType(status connection.Status) string {
+	switch status {
+	case connection.Connected:
+		return ConnEventConnected
+	case connection.Disconnected:
+		return ConnEventDisconnected
+	case connection.Reconnecting:
+		return ConnEventReconnecting
+	case connection.RegisteringTunnel:
+		return ConnEventRegistering
+	case connection.Unregistering:
+		return ConnEventUnregistering
+	case connection.SetURL:
+		return ConnEventSetURL
+	default:
+		return fmt.Sprintf("unknown(%d)", status)
+	}
+}
+
+// onTunnelEvent records observer events per HA connection and forwards them to the callbacks
+func (t *Tunnel) onTunnelEvent(event connection.Event) {
+	if event.EventType == connection.SetURL {
+		return
+	}
+
+	eventType := connEventType(event.EventType)
+	edgeIP := ""
+	if event.EdgeAddress != nil {
+		edgeIP = event.EdgeAddress.String()
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	info, ok := t.connections[event.Index]
+	if !ok {
+		info = &ConnectionInfo{Index: int(event.Index)}
+		t.connections[event.Index] = info
+	}
+	info.State = eventType
+	info.UpdatedAt = now.Format(time.RFC3339)
+	if event.Location != "" {
+		info.Location = event.Location
+	}
+	if edgeIP != "" {
+		info.EdgeIP = edgeIP
+	}
+	info.Protocol = event.Protocol.String()
+	if event.EventType == connection.Connected {
+		t.metrics.registrations.Inc()
+		if info.ConnectedAt != "" {
+			t.reconnectCount++
+			t.metrics.reconnects.Inc()
+		}
+		info.ConnectedAt = now.Format(time.RFC3339)
+	}
+	location, protocol := info.Location, info.Protocol
+	callback := t.connectionCallback
+	state := t.state
+	t.mu.Unlock()
+
+	t.logCallback(0, "[connection] #%d %s (location: %s, protocol: %s, edge: %s)", event.Index, eventType, location, protocol, edgeIP)
+
+	if callback != nil {
+		callback.OnConnectionEvent(int(event.Index), eventType, location, protocol, edgeIP)
+	}
+
+	if event.EventType == connection.Connected {
+		t.observeProtocol(event.Protocol)
+		t.recordKnownGoodEdge(edgeIP, protocol)
+		t.observeReconnect(event.Index)
+	}
+
+	t.updateStateFromConnections()
+
+	if event.EventType == connection.Connected || event.EventType == connection.Disconnected {
+		t.mu.RLock()
+		state = t.state
+		t.mu.RUnlock()
+		status := t.connectionsStatus()
+		t.notifyState(state, fmt.Sprintf("%d/%d connections (%s) via %s",
+			status.ActiveConnections, status.HAConnections, strings.Join(status.Locations, "/"), status.Protocol))
+	}
+}
+
+// readyConnections returns the number of HA connections currently registered
+func (t *Tunnel) readyConnections() uint {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var count uint
+	for _, info := range t.connections {
+		if info.State == ConnEventConnected {
+			count++
+		}
+	}
+	return count
+}
+
+// connectionsStatus returns a copy of the HA connection records
+func (t *Tunnel) connectionsStatus() ConnectionsStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	status := ConnectionsStatus{
+		HAConnections: t.config.HAConnections,
+		Protocol:      t.activeProtocol,
+		Locations:     make([]string, 0),
+		Connections:   make([]ConnectionInfo, 0, len(t.connections)),
+	}
+	seen := make(map[string]bool)
+	for _, info := range t.connections {
+		status.Connections = append(status.Connections, *info)
+		if info.State != ConnEventConnected {
+			continue
+		}
+		status.ActiveConnections++
+		if info.Location != "" && !seen[info.Location] {
+			seen[info.Location] = true
+			status.Locations = append(status.Locations, info.Location)
+		}
+	}
+	sort.Slice(status.Connections, func(i, j int) bool {
+		return status.Connections[i].Index < status.Connections[j].Index
+	})
+	sort.Strings(status.Locations)
+	return status
+}
+
+// GetConnections returns the status of the HA connections as JSON
+func (t *Tunnel) GetConnections() string {
+	data, err := json.Marshal(t.connectionsStatus())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// SetConnectionEventCallback sets the callback receiving per-connection events
+func (t *Tunnel) SetConnectionEventCallback(callback ConnectionEventCallback) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connectionCallback = callback
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// SetConnectionEventCallback sets the per-connection callback for the global tunnel,
+// including tunnels started later with the static API
+func SetConnectionEventCallback(callback ConnectionEventCallback) {
+	connectionCallbackMu.Lock()
+	globalConnectionCallback = callback
+	connectionCallbackMu.Unlock()
+
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel != nil {
+		globalTunnel.SetConnectionEventCallback(callback)
+	}
+}
+
+// SetConnectionEventCallbackByID sets the per-connection callback of a tunnel created with CreateTunnel
+func SetConnectionEventCallbackByID(id string, callback ConnectionEventCallback) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	tunnel.SetConnectionEventCallback(callback)
+	return nil
+}
+
+// GetConnections returns the HA connection status of the global tunnel as JSON
+func GetConnections() string {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel == nil {
+		return "{}"
+	}
+	return globalTunnel.GetConnections()
+}
+
+// GetConnectionsByID returns the HA connection status of a tunnel created with CreateTunnel
+func GetConnectionsByID(id string) (string, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return "", err
+	}
+	return tunnel.GetConnections(), nil
+}
@@ -0,0 +1,136 @@
+package mobile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// connectionEventRecorder is a ConnectionEventCallback that records "index:type" per event
+type connectionEventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *connectionEventRecorder) OnConnectionEvent(index int, eventType string, location string, protocol string, edgeIP string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%d:%s", index, eventType))
+}
+
+func (r *connectionEventRecorder) getEvents() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+// newConnectedTunnel returns a tunnel in the connected state that has not
+// recorded any connection yet, for feeding observer events by hand
+func newConnectedTunnel(t *testing.T) (*Tunnel, *connectionEventRecorder) {
+	t.Helper()
+	tunnel := newTunnel(&TunnelConfig{Token: testToken(t), HAConnections: 2}, nil)
+	recorder := &connectionEventRecorder{}
+	tunnel.SetConnectionEventCallback(recorder)
+
+	tunnel.mu.Lock()
+	defer tunnel.mu.Unlock()
+	tunnel.connections = make(map[uint8]*ConnectionInfo)
+	for _, state := range []TunnelState{StateConnecting, StateConnected} {
+		if _, err := tunnel.setStateLocked(state, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tunnel, recorder
+}
+
+// connectionsOf returns the decoded GetConnections of tunnel
+func connectionsOf(t *testing.T, tunnel *Tunnel) ConnectionsStatus {
+	t.Helper()
+	var status ConnectionsStatus
+	if err := json.Unmarshal([]byte(tunnel.GetConnections()), &status); err != nil {
+		t.Fatalf("invalid connections status: %v", err)
+	}
+	return status
+}
+
+// connectionStates returns the state of each recorded connection, by index
+func connectionStates(status ConnectionsStatus) map[int]string {
+	states := make(map[int]string, len(status.Connections))
+	for _, info := range status.Connections {
+		states[info.Index] = info.State
+	}
+	return states
+}
+
+func TestConnectionEvents(t *testing.T) {
+	tunnel, recorder := newConnectedTunnel(t)
+	connected := func(index uint8, location string, ip string) connection.Event {
+		return connection.Event{Index: index, EventType: connection.Connected, Location: location, Protocol: connection.QUIC, EdgeAddress: net.ParseIP(ip)}
+	}
+
+	tunnel.onTunnelEvent(connection.Event{Index: 0, EventType: connection.RegisteringTunnel})
+	tunnel.onTunnelEvent(connected(0, "LHR", "198.41.200.1"))
+	tunnel.onTunnelEvent(connected(1, "AMS", "198.41.200.2"))
+	tunnel.onTunnelEvent(connection.Event{EventType: connection.SetURL, URL: "https://example.com"})
+
+	status := connectionsOf(t, tunnel)
+	if status.ActiveConnections != 2 || status.HAConnections != 2 || status.Protocol != connection.QUIC.String() {
+		t.Errorf("active = %d/%d via %q, want 2/2 via %s", status.ActiveConnections, status.HAConnections, status.Protocol, connection.QUIC)
+	}
+	if !reflect.DeepEqual(status.Locations, []string{"AMS", "LHR"}) {
+		t.Errorf("locations = %v, want [AMS LHR]", status.Locations)
+	}
+	if len(status.Connections) != 2 || status.Connections[0].EdgeIP != "198.41.200.1" || status.Connections[0].ConnectedAt == "" {
+		t.Errorf("connections = %+v, want #0 and #1 with edge IPs and connect times", status.Connections)
+	}
+
+	// A connection that drops and comes back counts as a reconnect
+	tunnel.onTunnelEvent(connection.Event{Index: 0, EventType: connection.Disconnected})
+	status = connectionsOf(t, tunnel)
+	if status.ActiveConnections != 1 || !reflect.DeepEqual(status.Locations, []string{"AMS"}) {
+		t.Errorf("after #0 dropped: active = %d in %v, want 1 in [AMS]", status.ActiveConnections, status.Locations)
+	}
+	tunnel.onTunnelEvent(connection.Event{Index: 0, EventType: connection.Reconnecting})
+	if states := connectionStates(connectionsOf(t, tunnel)); states[0] != ConnEventReconnecting || states[1] != ConnEventConnected {
+		t.Errorf("connection states = %v, want #0 reconnecting and #1 connected", states)
+	}
+	tunnel.onTunnelEvent(connected(0, "FRA", "198.41.200.3"))
+	status = connectionsOf(t, tunnel)
+	if status.ActiveConnections != 2 || !reflect.DeepEqual(status.Locations, []string{"AMS", "FRA"}) {
+		t.Errorf("after #0 reconnected: active = %d in %v, want 2 in [AMS FRA]", status.ActiveConnections, status.Locations)
+	}
+	if info := tunnelInfo(t, tunnel); info.ReconnectCount != 1 {
+		t.Errorf("reconnectCount = %d, want 1", info.ReconnectCount)
+	}
+
+	// Losing every connection moves the tunnel to reconnecting until one is back
+	tunnel.onTunnelEvent(connection.Event{Index: 0, EventType: connection.Disconnected})
+	tunnel.onTunnelEvent(connection.Event{Index: 1, EventType: connection.Disconnected})
+	if status := connectionsOf(t, tunnel); status.ActiveConnections != 0 || len(status.Locations) != 0 {
+		t.Errorf("after all dropped: active = %d in %v, want none", status.ActiveConnections, status.Locations)
+	}
+	if state := TunnelState(tunnel.GetState()); state != StateReconnecting {
+		t.Errorf("state = %s with no connections, want reconnecting", state)
+	}
+	tunnel.onTunnelEvent(connected(1, "AMS", "198.41.200.2"))
+	if state := TunnelState(tunnel.GetState()); state != StateConnected {
+		t.Errorf("state = %s after a connection came back, want connected", state)
+	}
+	if info := tunnelInfo(t, tunnel); info.ReconnectCount != 2 {
+		t.Errorf("reconnectCount = %d, want 2", info.ReconnectCount)
+	}
+
+	want := []string{
+		"0:registering", "0:connected", "1:connected",
+		"0:disconnected", "0:reconnecting", "0:connected",
+		"0:disconnected", "1:disconnected", "1:connected",
+	}
+	if got := recorder.getEvents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("connection events = %v, want %v", got, want)
+	}
+}
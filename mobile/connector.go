@@ -0,0 +1,217 @@
+package mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+// connectorIDFilePrefix names the files persisting connector IDs in TunnelConfig.CacheDir
+const connectorIDFilePrefix = "connector-"
+
+// Tags set by the wrapper itself
+const (
+	connectorTagID       = "ID"
+	connectorTagPlatform = "platform"
+	defaultPlatform      = "mobile"
+)
+
+// ConnectorInfo is the JSON returned by GetConnectorInfo
+type ConnectorInfo struct {
+	ConnectorID string            `json:"connectorId"`
+	TunnelID    string            `json:"tunnelId"`
+	Version     string            `json:"version"`
+	Arch        string            `json:"arch"`
+	Tags        map[string]string `json:"tags"`
+}
+
+var (
+	// globalConnectorTags are applied to tunnels started by the static API
+	globalConnectorTags map[string]string
+	connectorTagsMu     sync.Mutex
+)
+
+// validateTags checks app-supplied connector tags
+func validateTags(tags map[string]string) error {
+	for name, value := range tags {
+		if name == "" || strings.ContainsAny(name, "= \t\n") {
+			return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid tag name %q", name))
+		}
+		if strings.EqualFold(name, connectorTagID) {
+			return newTunnelError(ErrCodeInvalidConfig, errors.New("the ID tag is reserved for the connector ID"))
+		}
+		if strings.ContainsAny(value, "\n") {
+			return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid value for tag %q", name))
+		}
+	}
+	return nil
+}
+
+// parseTagsJSON decodes a JSON object of tag names to values
+func parseTagsJSON(tagsJSON string) (map[string]string, error) {
+	var tags map[string]string
+	if err := json.Unmarshal([]byte(tagsJSON), &tags); err != nil {
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("failed to parse tags: %w", err))
+	}
+	if err := validateTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// connectorTags returns the tags sent to the edge: the connector ID, the platform
+// (overridable) and the app's tags, sorted by name
+func connectorTags(connectorID string, appTags map[string]string) []pogs.Tag {
+	tags := []pogs.Tag{{Name: connectorTagID, Value: connectorID}}
+	if _, ok := appTags[connectorTagPlatform]; !ok {
+		tags = append(tags, pogs.Tag{Name: connectorTagPlatform, Value: defaultPlatform})
+	}
+	names := make([]string, 0, len(appTags))
+	for name := range appTags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tags = append(tags, pogs.Tag{Name: name, Value: appTags[name]})
+	}
+	return tags
+}
+
+// connectorIDFor returns the connector ID to register with. It is, in order, the
+// configured ConnectorID, the one persisted in CacheDir for this tunnel, or
+// generated (the generated one is persisted when CacheDir is set).
+// Quick tunnels are ephemeral and always use the generated ID.
+func (t *Tunnel) connectorIDFor(tunnelID uuid.UUID, generated uuid.UUID) uuid.UUID {
+	t.mu.RLock()
+	configured := t.config.ConnectorID
+	cacheDir := t.config.CacheDir
+	quick := t.config.QuickTunnel
+	t.mu.RUnlock()
+
+	if configured != "" {
+		id, err := uuid.Parse(configured)
+		if err == nil {
+			return id
+		}
+		t.logCallback(1, "[connector] Ignoring invalid connector ID %q: %v", configured, err)
+	}
+	if quick {
+		return generated
+	}
+	if cacheDir == "" {
+		t.logCallback(1, "[connector] Neither ConnectorID nor CacheDir is set, using a new connector ID for this run")
+		return generated
+	}
+
+	path := filepath.Join(cacheDir, connectorIDFilePrefix+tunnelID.String())
+	if data, err := os.ReadFile(path); err == nil {
+		if id, err := uuid.Parse(strings.TrimSpace(string(data))); err == nil {
+			return id
+		}
+		t.logCallback(1, "[connector] Ignoring corrupt connector ID in %s", path)
+	}
+
+	err := os.MkdirAll(cacheDir, 0o700)
+	if err == nil {
+		err = writeFileAtomic(path, []byte(generated.String()))
+	}
+	if err != nil {
+		t.logCallback(1, "[connector] Failed to persist connector ID: %v", err)
+	}
+	return generated
+}
+
+// GetConnectorInfo returns the connector ID, tunnel ID, version and tags as JSON.
+// The connector ID and tunnel ID are set once the tunnel has started.
+func (t *Tunnel) GetConnectorInfo() string {
+	t.mu.RLock()
+	info := ConnectorInfo{
+		ConnectorID: t.connectorID,
+		TunnelID:    t.tunnelID,
+		Version:     Version,
+		Arch:        connectorArch,
+		Tags:        make(map[string]string),
+	}
+	for _, tag := range t.tags {
+		info.Tags[tag.Name] = tag.Value
+	}
+	t.mu.RUnlock()
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// SetTags replaces the app-supplied connector tags with a JSON object, e.g.
+// {"model": "Pixel 8", "appVersion": "1.2.0"}. Takes effect on the next Start.
+func (t *Tunnel) SetTags(tagsJSON string) error {
+	tags, err := parseTagsJSON(tagsJSON)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.config.Tags = tags
+	t.mu.Unlock()
+	return nil
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// SetConnectorTags sets the connector tags for tunnels started later with the
+// static API, as a JSON object of names to values. An empty string clears them.
+func SetConnectorTags(tagsJSON string) error {
+	var tags map[string]string
+	if tagsJSON != "" {
+		parsed, err := parseTagsJSON(tagsJSON)
+		if err != nil {
+			return err
+		}
+		tags = parsed
+	}
+
+	connectorTagsMu.Lock()
+	globalConnectorTags = tags
+	connectorTagsMu.Unlock()
+	return nil
+}
+
+// GetConnectorInfo returns the connector info of the global tunnel as JSON
+func GetConnectorInfo() string {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel == nil {
+		return "{}"
+	}
+	return globalTunnel.GetConnectorInfo()
+}
+
+// GetConnectorInfoByID returns the connector info of a tunnel created with CreateTunnel
+func GetConnectorInfoByID(id string) (string, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return "", err
+	}
+	return tunnel.GetConnectorInfo(), nil
+}
+
+// SetTagsByID sets the connector tags of a tunnel created with CreateTunnel
+func SetTagsByID(id string, tagsJSON string) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	return tunnel.SetTags(tagsJSON)
+}
@@ -0,0 +1,57 @@
+package mobile
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// runConnectorID starts a tunnel with config against edge, stops it and returns
+// the connector ID it registered with
+func runConnectorID(t *testing.T, edge *fakeEdge, config TunnelConfig) string {
+	t.Helper()
+	tunnel := newFakeEdgeTunnel(t, edge, &config, nil)
+	startConnected(t, tunnel)
+	if _, err := tunnel.StopWithTimeout(0); err != nil {
+		t.Fatal(err)
+	}
+
+	var info ConnectorInfo
+	if err := json.Unmarshal([]byte(tunnel.GetConnectorInfo()), &info); err != nil {
+		t.Fatalf("invalid connector info: %v", err)
+	}
+	if _, err := uuid.Parse(info.ConnectorID); err != nil {
+		t.Fatalf("connector ID %q: %v", info.ConnectorID, err)
+	}
+	return info.ConnectorID
+}
+
+func TestConnectorIDPersistence(t *testing.T) {
+	edge := newFakeEdge(t)
+	token := testToken(t)
+	cacheDir := t.TempDir()
+
+	// Two runs sharing a CacheDir register as the same connector
+	first := runConnectorID(t, edge, TunnelConfig{Token: token, CacheDir: cacheDir})
+	if second := runConnectorID(t, edge, TunnelConfig{Token: token, CacheDir: cacheDir}); second != first {
+		t.Errorf("second run with the same CacheDir used connector ID %s, want %s", second, first)
+	}
+
+	// Another tunnel in the same CacheDir gets its own ID
+	if other := runConnectorID(t, edge, TunnelConfig{Token: testToken(t), CacheDir: cacheDir}); other == first {
+		t.Errorf("another tunnel reused connector ID %s", first)
+	}
+
+	// A configured ID wins over the persisted one
+	configured := uuid.NewString()
+	if got := runConnectorID(t, edge, TunnelConfig{Token: token, CacheDir: cacheDir, ConnectorID: configured}); got != configured {
+		t.Errorf("connector ID = %s, want the configured %s", got, configured)
+	}
+
+	// Without a CacheDir or ConnectorID every run is a new connector
+	withoutCache := runConnectorID(t, edge, TunnelConfig{Token: token})
+	if again := runConnectorID(t, edge, TunnelConfig{Token: token}); again == withoutCache || again == first {
+		t.Errorf("run without CacheDir reused connector ID %s", again)
+	}
+}
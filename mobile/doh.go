@@ -0,0 +1,206 @@
+package mobile
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultDoHURL is the DNS-over-HTTPS endpoint used when ResolverConfig.DoHURL is empty
+const DefaultDoHURL = "https://cloudflare-dns.com/dns-query"
+
+// dohMediaType is the RFC 8484 content type of DNS wire-format messages
+const dohMediaType = "application/dns-message"
+
+// maxDoHResponseSize bounds a DoH response body (the DNS message size limit)
+const maxDoHResponseSize = 65535
+
+// defaultDoHBootstrapIPs reach cloudflare-dns.com without a DNS lookup
+var defaultDoHBootstrapIPs = []string{"1.1.1.1", "1.0.0.1"}
+
+// validateDoHConfig checks the DoH URL and bootstrap IPs and fills in defaults
+func validateDoHConfig(config *ResolverConfig) error {
+	if config.DoHURL == "" {
+		config.DoHURL = DefaultDoHURL
+		if len(config.BootstrapIPs) == 0 {
+			config.BootstrapIPs = append([]string(nil), defaultDoHBootstrapIPs...)
+		}
+	}
+	u, err := url.Parse(config.DoHURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid DoH URL %q: expected https://host/path", config.DoHURL))
+	}
+	for _, ip := range config.BootstrapIPs {
+		if net.ParseIP(ip) == nil {
+			return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid DoH bootstrap IP %q", ip))
+		}
+	}
+	return nil
+}
+
+// newDoHClient returns the HTTP client used for DoH queries. When bootstrap IPs
+// are set, the DoH host is reached through them instead of being resolved.
+func newDoHClient(config *ResolverConfig, timeout time.Duration, dial dialFunc) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.ForceAttemptHTTP2 = true
+	if config.rootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: config.rootCAs}
+	}
+
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dial(ctx, &net.Dialer{Timeout: timeout}, network, address)
+	}
+	if len(config.BootstrapIPs) > 0 {
+		bootstrapIPs := append([]string(nil), config.BootstrapIPs...)
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			_, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, err
+			}
+			var errs []error
+			for _, ip := range bootstrapIPs {
+				conn, err := dial(ctx, &net.Dialer{Timeout: timeout}, network, net.JoinHostPort(ip, port))
+				if err == nil {
+					return conn, nil
+				}
+				errs = append(errs, err)
+			}
+			return nil, errors.Join(errs...)
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// dohResolver returns a net.Resolver that sends its queries to a DoH server.
+// The Go resolver builds and parses the DNS messages; dohConn only carries them.
+func dohResolver(client *http.Client, endpoint string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, client: client, endpoint: endpoint}, nil
+		},
+	}
+}
+
+// dohConn is a packet connection that turns each written DNS query into an
+// RFC 8484 POST request and queues the response for the next read
+type dohConn struct {
+	ctx      context.Context
+	client   *http.Client
+	endpoint string
+
+	mu        sync.Mutex
+	responses [][]byte
+	deadline  time.Time
+}
+
+// exchange posts a DNS query and returns the DNS response
+func (c *dohConn) exchange(query []byte) ([]byte, error) {
+	ctx := c.ctx
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDoHResponseSize {
+		return nil, errors.New("DoH response too large")
+	}
+	return body, nil
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	response, err := c.exchange(b)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.responses = append(c.responses, response)
+	c.mu.Unlock()
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.responses) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.responses[0])
+	c.responses = c.responses[1:]
+	return n, nil
+}
+
+func (c *dohConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *dohConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *dohConn) Close() error {
+	return nil
+}
+
+func (c *dohConn) LocalAddr() net.Addr {
+	return dohAddr(c.endpoint)
+}
+
+func (c *dohConn) RemoteAddr() net.Addr {
+	return dohAddr(c.endpoint)
+}
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+// dohAddr is the net.Addr of a DoH endpoint
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,204 @@
+package mobile
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflared/features"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// directDial dials without the tunnel's socket accounting
+func directDial(ctx context.Context, d *net.Dialer, network, address string) (net.Conn, error) {
+	return d.DialContext(ctx, network, address)
+}
+
+// dohQueries records the questions a fake DoH server answered
+type dohQueries struct {
+	mu        sync.Mutex
+	questions []dnsmessage.Question
+}
+
+func (q *dohQueries) add(question dnsmessage.Question) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.questions = append(q.questions, question)
+}
+
+func (q *dohQueries) count() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.questions)
+}
+
+// asked reports whether a query of the given type and name was answered
+func (q *dohQueries) asked(qtype dnsmessage.Type, name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, question := range q.questions {
+		if question.Type == qtype && question.Name.String() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeDoHServer answers RFC 8484 POST queries with the edge SRV record, the
+// address of its target and a features record rolling out datagram v3 to all
+// accounts
+func fakeDoHServer(t *testing.T, queries *dohQueries) *httptest.Server {
+	t.Helper()
+	return fakeDoHServerFor(t, queries, netip.MustParseAddrPort("198.41.192.7:7844"))
+}
+
+// fakeDoHServerFor is fakeDoHServer with the edge discovered at the IPv4 address edge
+func fakeDoHServerFor(t *testing.T, queries *dohQueries, edge netip.AddrPort) *httptest.Server {
+	t.Helper()
+	srvName := dnsmessage.MustNewName("_" + edgeSRVService + "._" + edgeSRVProto + "." + edgeSRVName + ".")
+	target := dnsmessage.MustNewName("region1.v2." + edgeSRVName + ".")
+	featuresName := dnsmessage.MustNewName(featuresHostname + ".")
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "expected an RFC 8484 POST", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(body); err != nil || len(query.Questions) != 1 {
+			http.Error(w, "invalid DNS query", http.StatusBadRequest)
+			return
+		}
+		question := query.Questions[0]
+		queries.add(question)
+
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true},
+			Questions: query.Questions,
+		}
+		header := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		switch {
+		case question.Type == dnsmessage.TypeSRV && question.Name == srvName:
+			response.Answers = append(response.Answers, dnsmessage.Resource{
+				Header: header,
+				Body:   &dnsmessage.SRVResource{Priority: 1, Weight: 1, Port: edge.Port(), Target: target},
+			})
+		case question.Type == dnsmessage.TypeA && question.Name == target:
+			response.Answers = append(response.Answers, dnsmessage.Resource{
+				Header: header,
+				Body:   &dnsmessage.AResource{A: edge.Addr().As4()},
+			})
+		case question.Type == dnsmessage.TypeAAAA && question.Name == target:
+		case question.Type == dnsmessage.TypeTXT && question.Name == featuresName:
+			response.Answers = append(response.Answers, dnsmessage.Resource{
+				Header: header,
+				Body:   &dnsmessage.TXTResource{TXT: []string{`{"dv3_2":100}`}},
+			})
+		default:
+			response.RCode = dnsmessage.RCodeNameError
+		}
+
+		packed, err := response.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(packed)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// useDoHServer points the tunnel's resolver at a fake DoH server
+func useDoHServer(t *testing.T, tunnel *Tunnel, server *httptest.Server) {
+	t.Helper()
+	config := &ResolverConfig{Mode: ResolverModeDoH, DoHURL: server.URL + "/dns-query", TimeoutMs: 5000}
+	if err := normalizeResolverConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	config.rootCAs = x509.NewCertPool()
+	config.rootCAs.AddCert(server.Certificate())
+	tunnel.config.Resolver = config
+}
+
+func TestDoHEdgeDiscovery(t *testing.T) {
+	var queries dohQueries
+	server := fakeDoHServer(t, &queries)
+
+	config := &ResolverConfig{Mode: ResolverModeDoH, DoHURL: server.URL + "/dns-query", TimeoutMs: 5000}
+	if err := normalizeResolverConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	config.rootCAs = x509.NewCertPool()
+	config.rootCAs.AddCert(server.Certificate())
+
+	resolver := newTunnelResolver(config, directDial, func(int, string, ...interface{}) {})
+	defer resolver.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	addrs, err := resolver.resolveEdgeAddrs(ctx, "")
+	if err != nil {
+		t.Fatalf("edge discovery over DoH failed: %v", err)
+	}
+	if want := []string{"198.41.192.7:7844"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("edge addresses = %v, want %v", addrs, want)
+	}
+	if queries.count() < 2 {
+		t.Errorf("DoH server answered %d queries, want the SRV and address lookups", queries.count())
+	}
+}
+
+func TestDoHRejectsUntrustedServer(t *testing.T) {
+	var queries dohQueries
+	server := fakeDoHServer(t, &queries)
+
+	// Without the test server's certificate the TLS handshake must fail
+	config := &ResolverConfig{Mode: ResolverModeDoH, DoHURL: server.URL + "/dns-query", TimeoutMs: 2000}
+	if err := normalizeResolverConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	resolver := newTunnelResolver(config, directDial, func(int, string, ...interface{}) {})
+	defer resolver.Close()
+
+	if _, err := resolver.resolveEdgeAddrs(context.Background(), ""); err == nil {
+		t.Fatal("edge discovery succeeded against an untrusted DoH server")
+	}
+	if queries.count() != 0 {
+		t.Errorf("untrusted DoH server answered %d queries", queries.count())
+	}
+}
+
+func TestFeatureLookupUsesDoH(t *testing.T) {
+	var queries dohQueries
+	server := fakeDoHServer(t, &queries)
+
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+	useDoHServer(t, tunnel, server)
+	startConnected(t, tunnel)
+
+	if !queries.asked(dnsmessage.TypeTXT, featuresHostname+".") {
+		t.Errorf("the features record was not looked up over DoH (%d other queries)", queries.count())
+	}
+
+	resolver := tunnel.resolver()
+	defer resolver.Close()
+	if got := tunnel.lookupFeatures(context.Background(), resolver, "test-account"); !reflect.DeepEqual(got, []string{features.FeatureDatagramV3_2}) {
+		t.Errorf("lookupFeatures() = %v, want the rolled out %s", got, features.FeatureDatagramV3_2)
+	}
+}
@@ -0,0 +1,102 @@
+package mobile
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+// Edge IP version preferences for TunnelConfig.EdgeIPVersion (same values as cloudflared's --edge-ip-version)
+const (
+	EdgeIPVersionAuto = "auto"
+	EdgeIPVersion4    = "4"
+	EdgeIPVersion6    = "6"
+)
+
+// parseEdgeIPVersion maps an IP version preference to cloudflared's setting
+func parseEdgeIPVersion(version string) (allregions.ConfigIPVersion, error) {
+	switch strings.ToLower(strings.TrimSpace(version)) {
+	case "", EdgeIPVersionAuto:
+		return allregions.Auto, nil
+	case EdgeIPVersion4, "ipv4":
+		return allregions.IPv4Only, nil
+	case EdgeIPVersion6, "ipv6":
+		return allregions.IPv6Only, nil
+	default:
+		return allregions.Auto, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid edge IP version: %q (expected auto, 4 or 6)", version))
+	}
+}
+
+// validateRegion checks a region name such as "us"
+func validateRegion(region string) error {
+	for _, r := range region {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid region: %q", region))
+		}
+	}
+	return nil
+}
+
+// validateEdgeAddrs checks that each edge address is a "host:port"
+func validateEdgeAddrs(addrs []string) error {
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || host == "" {
+			return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid edge address %q: expected host:port", addr))
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid edge address port in %q", addr))
+		}
+	}
+	return nil
+}
+
+// filterEdgeAddrs keeps the addresses matching the IP version preference
+func filterEdgeAddrs(addrs []string, version allregions.ConfigIPVersion) []string {
+	if version == allregions.Auto {
+		return addrs
+	}
+	filtered := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		if (ip.To4() != nil) == (version == allregions.IPv4Only) {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
+
+// resolveStaticEdgeAddrs resolves the hostnames in configured edge addresses with
+// the tunnel resolver, so cloudflared only ever receives IP addresses
+func (r *tunnelResolver) resolveStaticEdgeAddrs(ctx context.Context, addrs []string) ([]string, error) {
+	resolved := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid edge address %q: %w", addr, err))
+		}
+		if net.ParseIP(host) != nil {
+			resolved = append(resolved, addr)
+			continue
+		}
+		ips, err := r.LookupIP(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			resolved = append(resolved, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,383 @@
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+// edgeCacheFilePrefix names the per-tunnel cache files inside TunnelConfig.CacheDir
+const edgeCacheFilePrefix = "edge-cache-"
+
+// DefaultEdgeCacheMaxAge is how long cached edge addresses are used before rediscovery
+const DefaultEdgeCacheMaxAge = 24 * time.Hour
+
+// defaultEdgePort is the edge port when an observer event only carries the IP
+const defaultEdgePort = "7844"
+
+// edgeCache is the persisted result of a successful edge discovery
+type edgeCache struct {
+	Region    string    `json:"region"`
+	Protocol  string    `json:"protocol,omitempty"`
+	KnownGood []string  `json:"knownGood"`
+	EdgeAddrs []string  `json:"edgeAddrs"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+var (
+	// globalCacheDir is applied to tunnels started by the static API
+	globalCacheDir string
+	// globalCacheTunnelID is the tunnel ID of the last global tunnel, whose
+	// cache the static ClearEdgeCache removes once that tunnel is gone
+	globalCacheTunnelID string
+	cacheDirMu          sync.Mutex
+)
+
+// edgeCacheFileName returns the cache file name of a tunnel, so tunnels sharing
+// a cache directory don't overwrite each other's edges and protocol
+func edgeCacheFileName(tunnelID string) string {
+	return edgeCacheFilePrefix + tunnelID + ".json"
+}
+
+// addrs returns the cached addresses with the ones known to work first
+func (c *edgeCache) addrs() []string {
+	seen := make(map[string]bool)
+	addrs := make([]string, 0, len(c.KnownGood)+len(c.EdgeAddrs))
+	for _, list := range [][]string{c.KnownGood, c.EdgeAddrs} {
+		for _, addr := range list {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+// cacheTunnelID returns the tunnel ID the cache files are keyed by, or "" for
+// quick tunnels, which get a new ID on every start
+func (t *Tunnel) cacheTunnelID() string {
+	t.mu.RLock()
+	quick := t.config.QuickTunnel
+	tokenStr := t.config.Token
+	t.mu.RUnlock()
+	if quick {
+		return ""
+	}
+	token, err := parseToken(tokenStr)
+	if err != nil {
+		return ""
+	}
+	return token.TunnelID.String()
+}
+
+// edgeCachePath returns the cache file path, or "" if caching is disabled
+func (t *Tunnel) edgeCachePath() string {
+	t.mu.RLock()
+	dir := t.config.CacheDir
+	t.mu.RUnlock()
+	if dir == "" {
+		return ""
+	}
+	tunnelID := t.cacheTunnelID()
+	if tunnelID == "" {
+		return ""
+	}
+	return filepath.Join(dir, edgeCacheFileName(tunnelID))
+}
+
+// edgeCacheMaxAge returns the configured cache expiry
+func (t *Tunnel) edgeCacheMaxAge() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.config.EdgeCacheMaxAgeSeconds > 0 {
+		return time.Duration(t.config.EdgeCacheMaxAgeSeconds) * time.Second
+	}
+	return DefaultEdgeCacheMaxAge
+}
+
+// loadEdgeCache reads the cached edge addresses for region. It returns nil if
+// caching is disabled or the cache is missing, unreadable, expired or for another region.
+func (t *Tunnel) loadEdgeCache(region string) *edgeCache {
+	path := t.edgeCachePath()
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			t.logCallback(1, "[edgecache] Failed to read %s: %v", path, err)
+		}
+		return nil
+	}
+
+	var cache edgeCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.logCallback(1, "[edgecache] Ignoring corrupt cache %s: %v", path, err)
+		return nil
+	}
+	if cache.Region != region {
+		t.logCallback(0, "[edgecache] Cache is for region %q, not %q", cache.Region, region)
+		return nil
+	}
+	if age := time.Since(cache.UpdatedAt); age > t.edgeCacheMaxAge() {
+		t.logCallback(0, "[edgecache] Cache expired (age %s)", age.Round(time.Second))
+		return nil
+	}
+	if len(cache.addrs()) == 0 {
+		return nil
+	}
+	return &cache
+}
+
+// saveEdgeCache writes the cache atomically
+func (t *Tunnel) saveEdgeCache(cache *edgeCache) {
+	path := t.edgeCachePath()
+	if path == "" {
+		return
+	}
+
+	data, err := json.Marshal(cache)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o700)
+	}
+	if err == nil {
+		err = writeFileAtomic(path, data)
+	}
+	if err != nil {
+		t.logCallback(1, "[edgecache] Failed to write %s: %v", path, err)
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// storeDiscoveredEdges caches a fresh discovery result, keeping the known-good
+// addresses that are still part of it
+func (t *Tunnel) storeDiscoveredEdges(region string, addrs []string) {
+	if t.edgeCachePath() == "" {
+		return
+	}
+
+	current := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		current[addr] = true
+	}
+	cache := &edgeCache{Region: region, EdgeAddrs: addrs, UpdatedAt: time.Now()}
+
+	t.mu.Lock()
+	if previous := t.edgeCache; previous != nil && previous.Region == region {
+		cache.Protocol = previous.Protocol
+		for _, addr := range previous.KnownGood {
+			if current[addr] {
+				cache.KnownGood = append(cache.KnownGood, addr)
+			}
+		}
+	}
+	t.edgeCache = cache
+	t.mu.Unlock()
+
+	t.saveEdgeCache(cache)
+}
+
+// recordKnownGoodEdge remembers an edge address and protocol that registered a connection
+func (t *Tunnel) recordKnownGoodEdge(edgeIP string, protocol string) {
+	if edgeIP == "" || t.edgeCachePath() == "" {
+		return
+	}
+
+	t.mu.Lock()
+	cache := t.edgeCache
+	if cache == nil {
+		t.mu.Unlock()
+		return
+	}
+	addr := net.JoinHostPort(edgeIP, defaultEdgePort)
+	for _, candidate := range cache.EdgeAddrs {
+		if host, _, err := net.SplitHostPort(candidate); err == nil && host == edgeIP {
+			addr = candidate
+			break
+		}
+	}
+	changed := cache.Protocol != protocol
+	found := false
+	for _, known := range cache.KnownGood {
+		if known == addr {
+			found = true
+			break
+		}
+	}
+	if !found {
+		cache.KnownGood = append(cache.KnownGood, addr)
+		changed = true
+	}
+	cache.Protocol = protocol
+	snapshot := *cache
+	snapshot.KnownGood = append([]string(nil), cache.KnownGood...)
+	t.mu.Unlock()
+
+	if changed {
+		t.saveEdgeCache(&snapshot)
+	}
+}
+
+// invalidateEdgeCache removes the cache after the cached addresses failed to connect
+func (t *Tunnel) invalidateEdgeCache() {
+	t.mu.Lock()
+	t.edgeCache = nil
+	t.mu.Unlock()
+
+	path := t.edgeCachePath()
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.logCallback(1, "[edgecache] Failed to remove %s: %v", path, err)
+	}
+}
+
+// cachedEdgeTimeout bounds the attempt on cached edge addresses: long enough
+// to dial and register once
+func cachedEdgeTimeout(rpcTimeout time.Duration) time.Duration {
+	return 2 * rpcTimeout
+}
+
+// discoverEdgeAddrs resolves the edge with the tunnel's resolver and caches the
+// result. Returns nil if that fails, leaving discovery to cloudflared.
+func (t *Tunnel) discoverEdgeAddrs(ctx context.Context, resolver *tunnelResolver, region string) []string {
+	t.notifyState(StateConnecting, "Resolving edge addresses...")
+	addrs, err := resolver.resolveEdgeAddrs(ctx, region)
+	if err != nil {
+		t.logCallback(1, "[runTunnel] Edge discovery failed, using cloudflared's discovery: %v", err)
+		return nil
+	}
+	t.logCallback(0, "[runTunnel] Resolved %d edge addresses", len(addrs))
+	t.storeDiscoveredEdges(region, addrs)
+	return addrs
+}
+
+// withRetries returns a copy of the run's daemon config with another retry budget
+func (r *daemonRun) withRetries(retries uint) *supervisor.TunnelConfig {
+	config := *r.config
+	config.Retries = retries
+	return &config
+}
+
+// withEdgeAddrs returns the run's daemon config for rediscovered edge addresses,
+// leaving discovery to cloudflared when none match the IP version. The caller
+// holds t.mu.
+func (r *daemonRun) withEdgeAddrs(addrs []string, retries uint) *supervisor.TunnelConfig {
+	config := r.withRetries(retries)
+	config.EdgeAddrs = filterEdgeAddrs(addrs, config.EdgeIPVersion)
+	if len(config.EdgeAddrs) == 0 {
+		config.EdgeAddrs = nil
+	}
+	r.edgeAddrs = addrs
+	return config
+}
+
+// cachedEdgeFailed reports whether this run used cached edge addresses without
+// registering any connection, and drops the cache if so
+func (t *Tunnel) cachedEdgeFailed() bool {
+	t.mu.RLock()
+	used := t.usedEdgeCache
+	connected := false
+	for _, info := range t.connections {
+		if info.ConnectedAt != "" {
+			connected = true
+			break
+		}
+	}
+	t.mu.RUnlock()
+
+	if !used || connected {
+		return false
+	}
+	t.invalidateEdgeCache()
+	return true
+}
+
+// SetCacheDir sets the directory where the tunnel persists its edge address cache.
+// An empty directory disables the cache. Takes effect on the next Start.
+func (t *Tunnel) SetCacheDir(dir string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.config.CacheDir = dir
+}
+
+// ClearEdgeCache removes the tunnel's cached edge addresses
+func (t *Tunnel) ClearEdgeCache() {
+	t.invalidateEdgeCache()
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// SetCacheDirectory sets the cache directory for tunnels started later with the
+// static API, typically the app's cache directory. An empty directory disables the cache.
+func SetCacheDirectory(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	cacheDirMu.Lock()
+	globalCacheDir = dir
+	cacheDirMu.Unlock()
+	return nil
+}
+
+// ClearEdgeCache removes the edge address cache of the global tunnel, or of
+// the last global tunnel if it has been stopped
+func ClearEdgeCache() {
+	tunnelMu.Lock()
+	tunnel := globalTunnel
+	tunnelMu.Unlock()
+
+	if tunnel != nil {
+		tunnel.ClearEdgeCache()
+		return
+	}
+	cacheDirMu.Lock()
+	dir := globalCacheDir
+	tunnelID := globalCacheTunnelID
+	cacheDirMu.Unlock()
+	if dir != "" && tunnelID != "" {
+		_ = os.Remove(filepath.Join(dir, edgeCacheFileName(tunnelID)))
+	}
+}
+
+// SetCacheDirByID sets the cache directory of a tunnel created with CreateTunnel
+func SetCacheDirByID(id string, dir string) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	tunnel.SetCacheDir(dir)
+	return nil
+}
@@ -0,0 +1,137 @@
+package mobile
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestEdgeCacheIsPerTunnel(t *testing.T) {
+	dir := t.TempDir()
+	first := newTunnel(&TunnelConfig{Token: testToken(t), CacheDir: dir}, nil)
+	second := newTunnel(&TunnelConfig{Token: testToken(t), CacheDir: dir}, nil)
+
+	first.storeDiscoveredEdges("", []string{"198.41.192.7:7844"})
+	first.recordKnownGoodEdge("198.41.192.7", ProtocolHTTP2)
+	second.storeDiscoveredEdges("", []string{"198.41.200.13:7844"})
+
+	if first.edgeCachePath() == second.edgeCachePath() {
+		t.Fatalf("tunnels share cache file %s", first.edgeCachePath())
+	}
+	cache := first.loadEdgeCache("")
+	if cache == nil || cache.Protocol != ProtocolHTTP2 || cache.addrs()[0] != "198.41.192.7:7844" {
+		t.Fatalf("first tunnel cache = %+v", cache)
+	}
+	if cache := second.loadEdgeCache(""); cache == nil || cache.Protocol != "" || cache.addrs()[0] != "198.41.200.13:7844" {
+		t.Fatalf("second tunnel cache = %+v", cache)
+	}
+
+	first.ClearEdgeCache()
+	if _, err := os.Stat(first.edgeCachePath()); !os.IsNotExist(err) {
+		t.Errorf("first cache not removed: %v", err)
+	}
+	if _, err := os.Stat(second.edgeCachePath()); err != nil {
+		t.Errorf("second cache removed with the first: %v", err)
+	}
+
+	quick := newTunnel(&TunnelConfig{QuickTunnel: true, CacheDir: dir}, nil)
+	if path := quick.edgeCachePath(); path != "" {
+		t.Errorf("quick tunnel cache path = %s, want none", path)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, edgeCacheFilePrefix+"*")); len(files) != 1 {
+		t.Errorf("cache files = %v, want only the second tunnel's", files)
+	}
+}
+
+func TestProtocolFallbackIsCached(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.blockUDP()
+	dir := t.TempDir()
+
+	// Seed the cache with the fake edge instead of configuring it, so the
+	// run takes its edge addresses from the cache
+	newCachedTunnel := func(token string) *Tunnel {
+		tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{Token: token, CacheDir: dir, Retries: 2}, nil)
+		tunnel.config.EdgeAddrs = nil
+		return tunnel
+	}
+	first := newCachedTunnel("")
+	first.storeDiscoveredEdges("", []string{edge.addr()})
+
+	startConnected(t, first)
+	if got := first.GetProtocol(); got != ProtocolHTTP2 {
+		t.Fatalf("protocol = %q, want fallback to %s", got, ProtocolHTTP2)
+	}
+	attempts := edge.getQUICAttempts()
+	if attempts == 0 {
+		t.Fatal("tunnel never tried QUIC")
+	}
+	cache := first.loadEdgeCache("")
+	if cache == nil || cache.Protocol != ProtocolHTTP2 || len(cache.KnownGood) != 1 || cache.KnownGood[0] != edge.addr() {
+		t.Fatalf("cache after fallback = %+v", cache)
+	}
+	if _, err := first.StopWithTimeout(5000); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run uses the cached protocol instead of trying QUIC again
+	second := newCachedTunnel(first.config.Token)
+	startConnected(t, second)
+	if got := second.GetProtocol(); got != ProtocolHTTP2 {
+		t.Errorf("protocol = %q, want cached %s", got, ProtocolHTTP2)
+	}
+	if n := edge.getQUICAttempts(); n != attempts {
+		t.Errorf("second run tried QUIC %d times despite the cached protocol", n-attempts)
+	}
+	second.mu.RLock()
+	usedCache := second.usedEdgeCache
+	second.mu.RUnlock()
+	if !usedCache {
+		t.Error("second run did not use the cached edge addresses")
+	}
+}
+
+func TestUnreachableCachedEdgeIsRediscovered(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.backoffRetries(500 * time.Millisecond)
+	var queries dohQueries
+	server := fakeDoHServerFor(t, &queries, netip.MustParseAddrPort(edge.addr()))
+
+	// The cached edge refuses connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := closed.Addr().String()
+	closed.Close()
+
+	// With the default retry budget the supervisor would back off for 15.5s
+	// before giving up on the cached edge
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{CacheDir: t.TempDir(), Retries: unsetCount}, nil)
+	tunnel.config.EdgeAddrs = nil
+	useDoHServer(t, tunnel, server)
+	tunnel.storeDiscoveredEdges("", []string{unreachable})
+
+	start := time.Now()
+	startConnected(t, tunnel)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("connected after %s, want the cached edge given up after one retry", elapsed.Round(time.Millisecond))
+	}
+	if !queries.asked(dnsmessage.TypeSRV, "_"+edgeSRVService+"._"+edgeSRVProto+"."+edgeSRVName+".") {
+		t.Error("the edge was not rediscovered")
+	}
+	if cache := tunnel.loadEdgeCache(""); cache == nil || len(cache.EdgeAddrs) != 1 || cache.EdgeAddrs[0] != edge.addr() {
+		t.Errorf("cache after rediscovery = %+v, want the discovered edge", cache)
+	}
+	tunnel.mu.RLock()
+	usedCache := tunnel.usedEdgeCache
+	tunnel.mu.RUnlock()
+	if usedCache {
+		t.Error("run still reports using the cached edge addresses")
+	}
+}
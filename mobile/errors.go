@@ -0,0 +1,270 @@
+package mobile
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// ErrorCode classifies tunnel errors reported via OnError
+type ErrorCode int
+
+const (
+	// ErrCodeUnknown is used for errors that don't match any other code
+	ErrCodeUnknown ErrorCode = iota + 1
+	// ErrCodeInvalidToken means the tunnel token is missing or malformed
+	ErrCodeInvalidToken
+	// ErrCodeInvalidConfig means the tunnel configuration is invalid
+	ErrCodeInvalidConfig
+	// ErrCodeDNSFailure means a DNS lookup (edge discovery, feature selection) failed
+	ErrCodeDNSFailure
+	// ErrCodeEdgeUnreachable means the Cloudflare edge could not be reached
+	ErrCodeEdgeUnreachable
+	// ErrCodeUDPBlocked means QUIC connections fail, usually because UDP 7844 is blocked
+	ErrCodeUDPBlocked
+	// ErrCodeTLSFailure means the TLS handshake with the edge failed
+	ErrCodeTLSFailure
+	// ErrCodeAuthRejected means the edge rejected the tunnel credentials
+	ErrCodeAuthRejected
+	// ErrCodeOriginUnreachable means cloudflared could not reach the origin service
+	ErrCodeOriginUnreachable
+	// ErrCodePanic means the tunnel recovered from a panic
+	ErrCodePanic
+	// ErrCodeAlreadyRunning means the tunnel is already running
+	ErrCodeAlreadyRunning
+	// ErrCodeTimeout means a wait on a TunnelHandle timed out
+	ErrCodeTimeout
+	// ErrCodeConnectTimeout means no edge connection was registered within ConnectTimeoutMs
+	ErrCodeConnectTimeout
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrCodeUnknown:
+		return "unknown"
+	case ErrCodeInvalidToken:
+		return "invalid_token"
+	case ErrCodeInvalidConfig:
+		return "invalid_config"
+	case ErrCodeDNSFailure:
+		return "dns_failure"
+	case ErrCodeEdgeUnreachable:
+		return "edge_unreachable"
+	case ErrCodeUDPBlocked:
+		return "udp_blocked"
+	case ErrCodeTLSFailure:
+		return "tls_failure"
+	case ErrCodeAuthRejected:
+		return "auth_rejected"
+	case ErrCodeOriginUnreachable:
+		return "origin_unreachable"
+	case ErrCodePanic:
+		return "panic"
+	case ErrCodeAlreadyRunning:
+		return "already_running"
+	case ErrCodeTimeout:
+		return "timeout"
+	case ErrCodeConnectTimeout:
+		return "connect_timeout"
+	default:
+		return "unknown"
+	}
+}
+
+// originErrorInterval limits how often origin errors from the logs are reported via OnError
+const originErrorInterval = 30 * time.Second
+
+// originUnreachableMsg is logged by cloudflared when a request to the origin fails
+const originUnreachableMsg = "Unable to reach the origin service"
+
+// TunnelError is an error with a code
+type TunnelError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *TunnelError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TunnelError) Unwrap() error {
+	return e.Err
+}
+
+// newTunnelError attaches a code to an error
+func newTunnelError(code ErrorCode, err error) error {
+	return &TunnelError{Code: code, Err: err}
+}
+
+// authRejectedMessages are the edge's reasons for refusing to register the
+// tunnel. The supervisor reports a refusal with the edge's message only, so
+// these are matched as text once no typed error applies.
+var authRejectedMessages = []string{
+	"Unauthorized",
+	"Invalid tunnel secret",
+	"tunnel not found",
+	"Tunnel not found",
+}
+
+// quicFailureMessages are quic-go's timeout messages, matched as text for
+// cloudflared errors that report them without wrapping the quic-go error
+var quicFailureMessages = []string{
+	"timeout: no recent network activity",
+	"timeout: handshake did not complete in time",
+}
+
+// classifyError maps an error returned by the token parser, feature selector,
+// supervisor etc. to an error code. Typed errors are matched first; message
+// text is only a last resort for errors that reach us as plain strings.
+func classifyError(err error) ErrorCode {
+	if err == nil {
+		return ErrCodeUnknown
+	}
+
+	var tunnelErr *TunnelError
+	if errors.As(err, &tunnelErr) {
+		return tunnelErr.Code
+	}
+
+	// The edge refused the registration. A permanent refusal is about the
+	// credentials; anything else is retried like an unreachable edge.
+	var registrationErr connection.ServerRegisterTunnelError
+	if errors.As(err, &registrationErr) {
+		if code := classifyError(registrationErr.Cause); code != ErrCodeUnknown {
+			return code
+		}
+		if registrationErr.Permanent {
+			return ErrCodeAuthRejected
+		}
+		return ErrCodeEdgeUnreachable
+	}
+	var dupConnErr connection.DupConnRegisterTunnelError
+	if errors.As(err, &dupConnErr) {
+		return ErrCodeEdgeUnreachable
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) || errors.Is(err, errDNSQuery) {
+		return ErrCodeDNSFailure
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalid x509.CertificateInvalidError
+	var certVerification *tls.CertificateVerificationError
+	var recordHeader tls.RecordHeaderError
+	var alert tls.AlertError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalid) ||
+		errors.As(err, &certVerification) || errors.As(err, &recordHeader) || errors.As(err, &alert) {
+		return ErrCodeTLSFailure
+	}
+
+	// A QUIC handshake that never completes, or a connection that never hears
+	// back, is what a network dropping UDP 7844 looks like
+	var idleTimeout *quic.IdleTimeoutError
+	var handshakeTimeout *quic.HandshakeTimeoutError
+	if errors.As(err, &idleTimeout) || errors.As(err, &handshakeTimeout) {
+		return ErrCodeUDPBlocked
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrCodeEdgeUnreachable
+	}
+
+	// Last resort: errors that only carry a message
+	msg := err.Error()
+	for _, m := range authRejectedMessages {
+		if strings.Contains(msg, m) {
+			return ErrCodeAuthRejected
+		}
+	}
+	for _, m := range quicFailureMessages {
+		if strings.Contains(msg, m) {
+			return ErrCodeUDPBlocked
+		}
+	}
+
+	return ErrCodeUnknown
+}
+
+// observeLogLine picks origin failures out of cloudflared's log output
+func (t *Tunnel) observeLogLine(line []byte) {
+	if !bytes.Contains(line, []byte(originUnreachableMsg)) {
+		return
+	}
+	var entry struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(line, &entry); err == nil {
+		t.observeOriginError(strings.TrimSpace(entry.Message + " " + entry.Error))
+	}
+}
+
+// observeOriginError reports origin failures logged by cloudflared via OnError,
+// at most once per originErrorInterval
+func (t *Tunnel) observeOriginError(message string) {
+	t.mu.Lock()
+	if time.Since(t.lastOriginError) < originErrorInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastOriginError = time.Now()
+	t.mu.Unlock()
+
+	if t.callback != nil {
+		t.callback.OnError(int(ErrCodeOriginUnreachable), message)
+	}
+}
+
+// GetLastErrorCode returns the code of the last error, or 0 if there was none
+func (t *Tunnel) GetLastErrorCode() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.lastError == nil {
+		return 0
+	}
+	return int(classifyError(t.lastError))
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// GetErrorCodeName returns the name of an error code (e.g. "invalid_token")
+func GetErrorCodeName(code int) string {
+	return ErrorCode(code).String()
+}
+
+// GetLastErrorCode returns the code of the global tunnel's last error, or 0 if there was none
+func GetLastErrorCode() int {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel == nil {
+		return 0
+	}
+	return globalTunnel.GetLastErrorCode()
+}
+
+// GetLastErrorCodeByID returns the last error code of a tunnel created with CreateTunnel
+func GetLastErrorCodeByID(id string) (int, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return 0, err
+	}
+	return tunnel.GetLastErrorCode(), nil
+}
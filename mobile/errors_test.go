@@ -0,0 +1,52 @@
+package mobile
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestClassifyError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCode
+	}{
+		{"nil", nil, ErrCodeUnknown},
+		{"tunnel error", fmt.Errorf("run: %w", newTunnelError(ErrCodeInvalidToken, errors.New("bad token"))), ErrCodeInvalidToken},
+		{"permanent registration error", connection.ServerRegisterTunnelError{Cause: errors.New("account suspended"), Permanent: true}, ErrCodeAuthRejected},
+		{"retryable registration error", connection.ServerRegisterTunnelError{Cause: errors.New("edge overloaded")}, ErrCodeEdgeUnreachable},
+		{"registration error with a transport cause", connection.ServerRegisterTunnelError{Cause: refused, Permanent: true}, ErrCodeEdgeUnreachable},
+		{"duplicate connection", fmt.Errorf("register: %w", connection.DupConnRegisterTunnelError{}), ErrCodeEdgeUnreachable},
+		{"DNS error", &net.DNSError{Err: "no such host", Name: "region1.v2.argotunnel.com", IsNotFound: true}, ErrCodeDNSFailure},
+		{"resolver query", fmt.Errorf("%w for %s: %w", errDNSQuery, "region1.v2.argotunnel.com", errors.New("i/o timeout")), ErrCodeDNSFailure},
+		{"unknown authority", x509.UnknownAuthorityError{}, ErrCodeTLSFailure},
+		{"TLS alert", fmt.Errorf("handshake: %w", tls.AlertError(40)), ErrCodeTLSFailure},
+		{"QUIC idle timeout", fmt.Errorf("failed to dial to edge with quic: %w", &quic.IdleTimeoutError{}), ErrCodeUDPBlocked},
+		{"QUIC handshake timeout", &quic.HandshakeTimeoutError{}, ErrCodeUDPBlocked},
+		{"connection refused", refused, ErrCodeEdgeUnreachable},
+		{"UDP write error", &net.OpError{Op: "write", Net: "udp", Err: syscall.ENETUNREACH}, ErrCodeEdgeUnreachable},
+		{"host unreachable", syscall.EHOSTUNREACH, ErrCodeEdgeUnreachable},
+		{"deadline", fmt.Errorf("dial: %w", os.ErrDeadlineExceeded), ErrCodeEdgeUnreachable},
+		{"context deadline", context.DeadlineExceeded, ErrCodeEdgeUnreachable},
+		{"edge rejection text", errors.New("Unauthorized: Invalid tunnel secret"), ErrCodeAuthRejected},
+		{"QUIC timeout text", errors.New("timeout: handshake did not complete in time"), ErrCodeUDPBlocked},
+		{"unrecognized", errors.New("something else"), ErrCodeUnknown},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("%s: classifyError(%v) = %s, want %s", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,670 @@
+package mobile
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/signal"
+	"github.com/cloudflare/cloudflared/supervisor"
+	"github.com/cloudflare/cloudflared/tunnelrpc"
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+	"github.com/google/uuid"
+	"github.com/quic-go/quic-go"
+)
+
+// fakeEdgeLocation is the colo the fake edge reports for every connection
+const fakeEdgeLocation = "TST"
+
+// fakeRegistration is the first line a fake edge connection sends, standing in
+// for the RegisterConnection RPC of cloudflared's registration client
+type fakeRegistration struct {
+	AccountTag  string            `json:"accountTag"`
+	TunnelID    string            `json:"tunnelId"`
+	ConnectorID string            `json:"connectorId"`
+	ConnIndex   uint8             `json:"connIndex"`
+	Tags        map[string]string `json:"tags"`
+
+	// Source is the IP the connection came from, filled in by the edge
+	Source string `json:"-"`
+}
+
+// fakeRegistrationReply is the edge's answer to a fakeRegistration
+type fakeRegistrationReply struct {
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// fakeUnregister is sent by a connection that shuts down gracefully
+const fakeUnregister = "unregister\n"
+
+// errFakeUDPBlocked is the error of QUIC dials while the edge blocks UDP
+var errFakeUDPBlocked error = &quic.IdleTimeoutError{}
+
+// fakeEdge is a local stand-in for the Cloudflare edge. Tunnels reach it through
+// the daemon it returns, which replaces supervisor.StartTunnelDaemon: like the
+// supervisor, the daemon dials the edge for each HA connection and serves it
+// with cloudflared's control stream, which registers the connection through a
+// fake registration client and reports it on the tunnel's observer.
+type fakeEdge struct {
+	listener net.Listener
+	open     atomic.Int64
+	wg       sync.WaitGroup
+
+	mu              sync.Mutex
+	conns           map[net.Conn]bool
+	registrations   []fakeRegistration
+	orchestrators   []*orchestration.Orchestrator
+	daemonConfigs   []*supervisor.TunnelConfig
+	unregistrations int
+	quicAttempts    int
+	rejectWith      string
+	hold            bool
+	ignoreGrace     bool
+	udpBlocked      bool
+	backoff         time.Duration
+}
+
+// newFakeEdge starts a fake edge on a loopback port. It is closed when the test ends.
+func newFakeEdge(tb testing.TB) *fakeEdge {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("failed to start fake edge: %v", err)
+	}
+	e := &fakeEdge{listener: listener, conns: make(map[net.Conn]bool)}
+	e.wg.Add(1)
+	go e.serve()
+	tb.Cleanup(func() {
+		listener.Close()
+		e.dropConnections()
+		e.wg.Wait()
+	})
+	return e
+}
+
+func (e *fakeEdge) serve() {
+	defer e.wg.Done()
+	for {
+		conn, err := e.listener.Accept()
+		if err != nil {
+			return
+		}
+		e.open.Add(1)
+		e.mu.Lock()
+		e.conns[conn] = true
+		e.mu.Unlock()
+
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			defer e.open.Add(-1)
+			defer func() {
+				e.mu.Lock()
+				delete(e.conns, conn)
+				e.mu.Unlock()
+				conn.Close()
+			}()
+			e.handle(conn)
+		}()
+	}
+}
+
+// handle registers one connection and serves it until the tunnel closes it
+func (e *fakeEdge) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var registration fakeRegistration
+	if err := json.Unmarshal(line, &registration); err != nil {
+		return
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		registration.Source = addr.IP.String()
+	}
+
+	e.mu.Lock()
+	hold := e.hold
+	reply := fakeRegistrationReply{Location: fakeEdgeLocation}
+	if e.rejectWith != "" {
+		reply = fakeRegistrationReply{Error: e.rejectWith}
+	} else if !hold {
+		e.registrations = append(e.registrations, registration)
+	}
+	e.mu.Unlock()
+
+	if hold {
+		// Never answer, like an edge that accepts TCP but never registers
+		_, _ = io.Copy(io.Discard, reader)
+		return
+	}
+	data, _ := json.Marshal(reply)
+	if _, err := conn.Write(append(data, '\n')); err != nil || reply.Error != "" {
+		return
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line == fakeUnregister {
+			e.mu.Lock()
+			e.unregistrations++
+			e.mu.Unlock()
+		}
+	}
+}
+
+// addr returns the address tunnels should use as their edge
+func (e *fakeEdge) addr() string {
+	return e.listener.Addr().String()
+}
+
+// openConns returns the number of connections the edge has not seen closed yet
+func (e *fakeEdge) openConns() int64 {
+	return e.open.Load()
+}
+
+// reject makes the edge refuse new registrations with msg ("" accepts them again)
+func (e *fakeEdge) reject(msg string) {
+	e.mu.Lock()
+	e.rejectWith = msg
+	e.mu.Unlock()
+}
+
+// holdRegistrations makes the edge accept connections without ever answering
+func (e *fakeEdge) holdRegistrations() {
+	e.mu.Lock()
+	e.hold = true
+	e.mu.Unlock()
+}
+
+// ignoreGraceShutdown makes the daemon keep its connections open after a
+// graceful shutdown request, so only a forced stop ends it
+func (e *fakeEdge) ignoreGraceShutdown() {
+	e.mu.Lock()
+	e.ignoreGrace = true
+	e.mu.Unlock()
+}
+
+// blockUDP makes QUIC connections to the edge fail, like a network dropping UDP 7844
+func (e *fakeEdge) blockUDP() {
+	e.mu.Lock()
+	e.udpBlocked = true
+	e.mu.Unlock()
+}
+
+// backoffRetries makes the daemon wait before each retry of a failed
+// connection, doubling from base like the supervisor's backoff
+func (e *fakeEdge) backoffRetries(base time.Duration) {
+	e.mu.Lock()
+	e.backoff = base
+	e.mu.Unlock()
+}
+
+// dropConnections closes every connection from the edge side
+func (e *fakeEdge) dropConnections() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for conn := range e.conns {
+		conn.Close()
+	}
+}
+
+// getRegistrations returns the registrations the edge accepted
+func (e *fakeEdge) getRegistrations() []fakeRegistration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]fakeRegistration(nil), e.registrations...)
+}
+
+// getOrchestrators returns the orchestrator of each daemon started against the edge
+func (e *fakeEdge) getOrchestrators() []*orchestration.Orchestrator {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*orchestration.Orchestrator(nil), e.orchestrators...)
+}
+
+// getDaemonConfigs returns the supervisor config of each daemon started against the edge
+func (e *fakeEdge) getDaemonConfigs() []*supervisor.TunnelConfig {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*supervisor.TunnelConfig(nil), e.daemonConfigs...)
+}
+
+// getUnregistrations returns the number of connections that unregistered gracefully
+func (e *fakeEdge) getUnregistrations() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.unregistrations
+}
+
+// getQUICAttempts returns the number of QUIC connections tried while UDP was blocked
+func (e *fakeEdge) getQUICAttempts() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.quicAttempts
+}
+
+// daemon returns the tunnelDaemon that connects tunnels to the edge. Each HA
+// connection is served by its own goroutine, which redials when the edge drops
+// it or a reconnect signal arrives. Like the supervisor, it falls back to the
+// selector's fallback protocol when a connection fails, and gives up after
+// config.Retries failures or a permanent registration error.
+func (e *fakeEdge) daemon() tunnelDaemon {
+	return func(ctx context.Context, config *supervisor.TunnelConfig, orchestrator *orchestration.Orchestrator, connectedSignal *signal.Signal, reconnectCh chan supervisor.ReconnectSignal, graceShutdownC <-chan struct{}) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		e.mu.Lock()
+		e.orchestrators = append(e.orchestrators, orchestrator)
+		e.daemonConfigs = append(e.daemonConfigs, config)
+		e.mu.Unlock()
+
+		var wg sync.WaitGroup
+		errC := make(chan error, config.HAConnections)
+		for i := 0; i < config.HAConnections; i++ {
+			wg.Add(1)
+			go func(index uint8) {
+				defer wg.Done()
+				if err := e.serveConnection(ctx, config, orchestrator, index, connectedSignal, reconnectCh, graceShutdownC); err != nil {
+					errC <- err
+					cancel()
+				}
+			}(uint8(i))
+		}
+		wg.Wait()
+
+		select {
+		case err := <-errC:
+			return err
+		default:
+			return nil
+		}
+	}
+}
+
+// serveConnection keeps one HA connection registered until shutdown
+func (e *fakeEdge) serveConnection(ctx context.Context, config *supervisor.TunnelConfig, orchestrator *orchestration.Orchestrator, index uint8, connectedSignal *signal.Signal, reconnectCh chan supervisor.ReconnectSignal, graceShutdownC <-chan struct{}) error {
+	e.mu.Lock()
+	ignoreGrace := e.ignoreGrace
+	e.mu.Unlock()
+	if ignoreGrace {
+		graceShutdownC = nil
+	}
+
+	protocol := config.ProtocolSelector.Current()
+	failures := uint(0)
+	for {
+		reconnect, err := e.serveOnce(ctx, config, orchestrator, index, protocol, failures, connectedSignal, reconnectCh, graceShutdownC)
+		if ctx.Err() != nil {
+			config.Observer.SendDisconnect(index)
+			return nil
+		}
+		if err != nil {
+			// The supervisor reports the edge's own error for failed registrations
+			var registrationErr connection.ServerRegisterTunnelError
+			if errors.As(err, &registrationErr) {
+				if registrationErr.Permanent {
+					return registrationErr.Cause
+				}
+				err = registrationErr.Cause
+			}
+			failures++
+			if failures > config.Retries {
+				return err
+			}
+			e.mu.Lock()
+			backoff := e.backoff << (failures - 1)
+			e.mu.Unlock()
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				config.Observer.SendDisconnect(index)
+				return nil
+			}
+			if fallback, ok := config.ProtocolSelector.Fallback(); ok && fallback != protocol {
+				protocol = fallback
+			}
+			config.Observer.SendReconnect(index)
+			continue
+		}
+		failures = 0
+
+		if !reconnect {
+			config.Observer.SendDisconnect(index)
+			return nil
+		}
+		config.Observer.SendReconnect(index)
+	}
+}
+
+// serveOnce dials the edge and serves one connection until it is lost, a
+// reconnect is requested or the tunnel shuts down. It reports whether the
+// connection should be re-established.
+func (e *fakeEdge) serveOnce(ctx context.Context, config *supervisor.TunnelConfig, orchestrator *orchestration.Orchestrator, index uint8, protocol connection.Protocol, previousAttempts uint, connectedSignal *signal.Signal, reconnectCh chan supervisor.ReconnectSignal, graceShutdownC <-chan struct{}) (bool, error) {
+	if protocol == connection.QUIC {
+		e.mu.Lock()
+		blocked := e.udpBlocked
+		if blocked {
+			e.quicAttempts++
+		}
+		e.mu.Unlock()
+		if blocked {
+			return false, fmt.Errorf("failed to dial to edge with quic: %w", errFakeUDPBlocked)
+		}
+	}
+	if len(config.EdgeAddrs) == 0 {
+		return false, errors.New("fake edge cannot be discovered, set the edge addresses")
+	}
+
+	addr := config.EdgeAddrs[int(index)%len(config.EdgeAddrs)]
+	dialer := &net.Dialer{LocalAddr: bindAddr(config.EdgeBindAddr)}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	edgeIP := conn.RemoteAddr().(*net.TCPAddr).IP
+
+	fuse := &fakeConnectedFuse{signal: connectedSignal, connected: make(chan struct{})}
+	registerClient := func(_ context.Context, stream io.ReadWriteCloser, timeout time.Duration) tunnelrpc.RegistrationClient {
+		return &fakeRegistrationClient{stream: stream, timeout: timeout, tags: config.Tags}
+	}
+	controlStream := connection.NewControlStream(config.Observer, fuse, config.NamedTunnel, index, edgeIP,
+		registerClient, config.RPCTimeout, graceShutdownC, config.GracePeriod, protocol)
+	options := &pogs.ConnectionOptions{
+		Client: pogs.ClientInfo{
+			ClientID: config.ClientConfig.ConnectorID[:],
+			Version:  config.ReportedVersion,
+		},
+		NumPreviousAttempts: uint8(previousAttempts),
+	}
+
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- controlStream.ServeControlStream(serveCtx, conn, options, orchestrator)
+	}()
+
+	select {
+	case err := <-served:
+		return false, err
+	case <-fuse.connected:
+	}
+
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	reconnect := false
+	stopped := false
+	select {
+	case <-served:
+		// Unregistered after a graceful shutdown
+		stopped = true
+	case <-ctx.Done():
+	case <-reconnectCh:
+		reconnect = true
+	case <-lost:
+		reconnect = true
+	}
+	cancel()
+	if !stopped {
+		<-served
+	}
+	conn.Close()
+	<-lost
+	return reconnect, nil
+}
+
+// fakeConnectedFuse notifies the tunnel's connected signal once a connection
+// registers, like the supervisor's fuse
+type fakeConnectedFuse struct {
+	signal    *signal.Signal
+	once      sync.Once
+	connected chan struct{}
+}
+
+func (f *fakeConnectedFuse) Connected() {
+	f.once.Do(func() { close(f.connected) })
+	f.signal.Notify()
+}
+
+func (f *fakeConnectedFuse) IsConnected() bool {
+	select {
+	case <-f.connected:
+		return true
+	default:
+		return false
+	}
+}
+
+// fakeRegistrationClient replaces cloudflared's capnp registration client with
+// the fake edge's line protocol. Like the real client, it returns the message
+// of an edge that refuses the registration as the error.
+type fakeRegistrationClient struct {
+	stream  io.ReadWriteCloser
+	timeout time.Duration
+	tags    []pogs.Tag
+}
+
+func (c *fakeRegistrationClient) RegisterConnection(ctx context.Context, auth pogs.TunnelAuth, tunnelID uuid.UUID, options *pogs.ConnectionOptions, connIndex uint8, _ net.IP) (*pogs.ConnectionDetails, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	// Unblock the registration when it times out or the run is cancelled
+	stop := context.AfterFunc(ctx, func() { c.stream.Close() })
+	defer stop()
+
+	connectorID, err := uuid.FromBytes(options.Client.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(c.tags))
+	for _, tag := range c.tags {
+		tags[tag.Name] = tag.Value
+	}
+	data, _ := json.Marshal(fakeRegistration{
+		AccountTag:  auth.AccountTag,
+		TunnelID:    tunnelID.String(),
+		ConnectorID: connectorID.String(),
+		ConnIndex:   connIndex,
+		Tags:        tags,
+	})
+	if _, err := c.stream.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+
+	// Read byte by byte so nothing after the reply is buffered away
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		if _, err := c.stream.Read(buf); err != nil {
+			return nil, fmt.Errorf("failed to register connection %d: %w", connIndex, err)
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	var reply fakeRegistrationReply
+	if err := json.Unmarshal(line, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return &pogs.ConnectionDetails{UUID: uuid.New(), Location: reply.Location}, nil
+}
+
+func (c *fakeRegistrationClient) SendLocalConfiguration(context.Context, []byte) error {
+	return nil
+}
+
+// GracefulShutdown unregisters the connection, unless the run was already cancelled
+func (c *fakeRegistrationClient) GracefulShutdown(ctx context.Context, _ time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.stream, fakeUnregister)
+	return err
+}
+
+func (c *fakeRegistrationClient) Close() {}
+
+// bindAddr returns the local TCP address for a bind IP, or nil
+func bindAddr(ip net.IP) net.Addr {
+	if ip == nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip}
+}
+
+// testToken returns a tunnel token for a random tunnel ID
+func testToken(tb testing.TB) string {
+	tb.Helper()
+	data, err := json.Marshal(connection.TunnelToken{
+		AccountTag:   "test-account",
+		TunnelSecret: []byte("test-secret-test-secret-test-sec"),
+		TunnelID:     uuid.New(),
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// newFakeEdgeTunnel returns a tunnel that connects to edge. DNS lookups go to a
+// closed loopback port so nothing leaves the machine.
+func newFakeEdgeTunnel(tb testing.TB, edge *fakeEdge, config *TunnelConfig, callback TunnelCallback) *Tunnel {
+	tb.Helper()
+	if config.Token == "" {
+		config.Token = testToken(tb)
+	}
+	if config.HAConnections < 1 {
+		config.HAConnections = 2
+	}
+	config.EdgeAddrs = []string{edge.addr()}
+	config.Resolver = &ResolverConfig{
+		Mode:      ResolverModeCustom,
+		Servers:   []string{"127.0.0.1:1"},
+		Transport: ResolverTransportUDP,
+		TimeoutMs: 500,
+	}
+	tunnel := newTunnel(config, callback)
+	tunnel.daemon = edge.daemon()
+	return tunnel
+}
+
+// recordedState is one OnStateChanged call
+type recordedState struct {
+	State   TunnelState
+	Message string
+}
+
+// recordedError is one OnError call
+type recordedError struct {
+	Code    ErrorCode
+	Message string
+}
+
+// callbackRecorder is a TunnelCallback that records what it receives
+type callbackRecorder struct {
+	mu     sync.Mutex
+	states []recordedState
+	errors []recordedError
+	logs   []string
+}
+
+func (r *callbackRecorder) OnStateChanged(state int, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, recordedState{State: TunnelState(state), Message: message})
+}
+
+func (r *callbackRecorder) OnError(code int, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, recordedError{Code: ErrorCode(code), Message: message})
+}
+
+func (r *callbackRecorder) OnLog(level int, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logs = append(r.logs, message)
+}
+
+// getStates returns the recorded state notifications
+func (r *callbackRecorder) getStates() []recordedState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]recordedState(nil), r.states...)
+}
+
+// getLogs returns the recorded log messages
+func (r *callbackRecorder) getLogs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.logs...)
+}
+
+// getErrors returns the recorded errors
+func (r *callbackRecorder) getErrors() []recordedError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]recordedError(nil), r.errors...)
+}
+
+// transitions returns the states the tunnel moved through, from its state history
+func transitions(tunnel *Tunnel) []string {
+	tunnel.mu.RLock()
+	defer tunnel.mu.RUnlock()
+	states := make([]string, 0, len(tunnel.stateHistory))
+	for _, transition := range tunnel.stateHistory {
+		states = append(states, transition.To)
+	}
+	return states
+}
+
+// waitFor polls cond until it holds or timeout elapses
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stuckDaemon returns a tunnelDaemon that ignores shutdown until release is
+// closed, like a run stuck in a blocking call. entered is closed once it runs.
+func stuckDaemon(entered chan<- struct{}, release <-chan struct{}) tunnelDaemon {
+	return func(context.Context, *supervisor.TunnelConfig, *orchestration.Orchestrator, *signal.Signal, chan supervisor.ReconnectSignal, <-chan struct{}) error {
+		close(entered)
+		<-release
+		return nil
+	}
+}
@@ -0,0 +1,65 @@
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/cloudflare/cloudflared/features"
+)
+
+// featuresHostname holds the TXT record cloudflared reads its account-based
+// feature rollout from
+const featuresHostname = "cfd-features.argotunnel.com"
+
+// featuresRecord is the JSON in the features TXT record: the percentage of
+// accounts each feature is rolled out to
+type featuresRecord struct {
+	DatagramV3Percentage uint32 `json:"dv3_2"`
+}
+
+// lookupFeatures reads the features TXT record through the tunnel resolver and
+// returns the features rolled out to the account, the way cloudflared's feature
+// selector picks them. The selector itself can only query the system resolver,
+// so the result is handed to it as CLI features. Returns nil when the record
+// can't be read; the selector's defaults apply then.
+func (t *Tunnel) lookupFeatures(ctx context.Context, resolver *tunnelResolver, accountTag string) []string {
+	record, err := lookupFeaturesRecord(ctx, resolver)
+	if err != nil {
+		t.logCallback(1, "[features] Using default features: %v", err)
+		return nil
+	}
+
+	threshold := accountThreshold(accountTag)
+	var enabled []string
+	if record.DatagramV3Percentage > threshold {
+		enabled = append(enabled, features.FeatureDatagramV3_2)
+	}
+	t.logCallback(0, "[features] Rolled out to this account: %v", enabled)
+	return enabled
+}
+
+// lookupFeaturesRecord fetches and decodes the features TXT record
+func lookupFeaturesRecord(ctx context.Context, resolver *tunnelResolver) (*featuresRecord, error) {
+	records, err := resolver.LookupTXT(ctx, featuresHostname)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no TXT record for %s", featuresHostname)
+	}
+	var record featuresRecord
+	if err := json.Unmarshal([]byte(records[0]), &record); err != nil {
+		return nil, fmt.Errorf("invalid features record %q: %w", records[0], err)
+	}
+	return &record, nil
+}
+
+// accountThreshold maps an account to a stable percentile in [0, 100); a
+// feature is enabled for the account when its rollout percentage exceeds it
+func accountThreshold(accountTag string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(accountTag))
+	return h.Sum32() % 100
+}
@@ -0,0 +1,117 @@
+package mobile
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TunnelInfo is the JSON returned by GetTunnelInfo and ValidateToken.
+// ValidateToken only fills in the fields read from the token.
+type TunnelInfo struct {
+	TunnelID            string `json:"tunnelId"`
+	AccountTag          string `json:"accountTag"`
+	Endpoint            string `json:"endpoint"`
+	Region              string `json:"region"`
+	ConnectorID         string `json:"connectorId,omitempty"`
+	Protocol            string `json:"protocol,omitempty"`
+	State               string `json:"state,omitempty"`
+	ConnectedAt         string `json:"connectedAt,omitempty"`
+	LastReconnectAt     string `json:"lastReconnectAt,omitempty"`
+	UptimeSeconds       int64  `json:"uptimeSeconds"`
+	ReconnectCount      int    `json:"reconnectCount"`
+	LastError           string `json:"lastError,omitempty"`
+	LastErrorCode       int    `json:"lastErrorCode,omitempty"`
+	LastErrorName       string `json:"lastErrorName,omitempty"`
+	QuickTunnelHostname string `json:"quickTunnelHostname,omitempty"`
+}
+
+// tokenInfo returns the metadata of a tunnel token, without the secret
+func tokenInfo(token string) (*TunnelInfo, error) {
+	parsed, err := parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return &TunnelInfo{
+		TunnelID:   parsed.TunnelID.String(),
+		AccountTag: parsed.AccountTag,
+		Endpoint:   parsed.Endpoint,
+		Region:     parsed.Endpoint,
+	}, nil
+}
+
+// GetInfo returns the tunnel's identity, uptime, reconnect count and last error as JSON.
+// Uptime counts from the first connection of the current run and survives
+// reconnects; lastReconnectAt is when the tunnel last recovered from one.
+func (t *Tunnel) GetInfo() string {
+	t.mu.RLock()
+	token, quick, region := t.config.Token, t.config.QuickTunnel, t.config.Region
+	t.mu.RUnlock()
+
+	info := &TunnelInfo{}
+	if !quick {
+		if parsed, err := tokenInfo(token); err == nil {
+			info = parsed
+		}
+	}
+	if region != "" {
+		info.Region = region
+	}
+
+	t.mu.RLock()
+	if t.tunnelID != "" {
+		info.TunnelID = t.tunnelID
+	}
+	if t.accountTag != "" {
+		info.AccountTag = t.accountTag
+	}
+	info.ConnectorID = t.connectorID
+	info.Protocol = t.activeProtocol
+	info.State = t.state.String()
+	info.ReconnectCount = t.reconnectCount
+	info.QuickTunnelHostname = t.quickTunnelHostname
+	if !t.connectedAt.IsZero() {
+		info.ConnectedAt = t.connectedAt.Format(time.RFC3339)
+		info.UptimeSeconds = int64(time.Since(t.connectedAt).Seconds())
+	}
+	if !t.lastReconnectAt.IsZero() {
+		info.LastReconnectAt = t.lastReconnectAt.Format(time.RFC3339)
+	}
+	lastError := t.lastError
+	t.mu.RUnlock()
+
+	if lastError != nil {
+		code := classifyError(lastError)
+		info.LastError = lastError.Error()
+		info.LastErrorCode = int(code)
+		info.LastErrorName = code.String()
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// GetTunnelInfo returns the info of the global tunnel as JSON
+func GetTunnelInfo() string {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel == nil {
+		return "{}"
+	}
+	return globalTunnel.GetInfo()
+}
+
+// GetTunnelInfoByID returns the info of a tunnel created with CreateTunnel as JSON
+func GetTunnelInfoByID(id string) (string, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return "", err
+	}
+	return tunnel.GetInfo(), nil
+}
@@ -0,0 +1,104 @@
+package mobile
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/google/uuid"
+)
+
+func TestTunnelInfoUptimeSurvivesReconnect(t *testing.T) {
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+	startConnected(t, tunnel)
+
+	// Pretend the tunnel has been up for a minute
+	tunnel.mu.Lock()
+	tunnel.connectedAt = tunnel.connectedAt.Add(-time.Minute)
+	connectedAt := tunnel.connectedAt.Format(time.RFC3339)
+	tunnel.mu.Unlock()
+	if info := tunnelInfo(t, tunnel); info.ConnectedAt != connectedAt || info.LastReconnectAt != "" {
+		t.Fatalf("connectedAt = %q, lastReconnectAt = %q; want %q and none", info.ConnectedAt, info.LastReconnectAt, connectedAt)
+	}
+
+	edge.dropConnections()
+	if !waitFor(5*time.Second, func() bool {
+		return slices.Contains(transitions(tunnel), StateReconnecting.String()) && tunnel.IsConnected()
+	}) {
+		t.Fatalf("tunnel did not reconnect: %v", transitions(tunnel))
+	}
+
+	info := tunnelInfo(t, tunnel)
+	if info.ConnectedAt != connectedAt {
+		t.Errorf("connectedAt = %q after a reconnect, want %q", info.ConnectedAt, connectedAt)
+	}
+	if info.UptimeSeconds < 60 {
+		t.Errorf("uptimeSeconds = %d after a reconnect, want at least 60", info.UptimeSeconds)
+	}
+	if info.LastReconnectAt == "" {
+		t.Error("lastReconnectAt not set after a reconnect")
+	}
+
+	// A stopped tunnel has no uptime, and the next run counts from its own connection
+	if _, err := tunnel.StopWithTimeout(0); err != nil {
+		t.Fatal(err)
+	}
+	if info := tunnelInfo(t, tunnel); info.ConnectedAt != "" || info.UptimeSeconds != 0 || info.LastReconnectAt != "" {
+		t.Errorf("stopped tunnel: connectedAt = %q, uptimeSeconds = %d, lastReconnectAt = %q; want none",
+			info.ConnectedAt, info.UptimeSeconds, info.LastReconnectAt)
+	}
+	startConnected(t, tunnel)
+	if info := tunnelInfo(t, tunnel); info.UptimeSeconds >= 60 || info.LastReconnectAt != "" {
+		t.Errorf("restarted tunnel: uptimeSeconds = %d, lastReconnectAt = %q; want a fresh run", info.UptimeSeconds, info.LastReconnectAt)
+	}
+}
+
+func TestValidateTokenReturnsMetadata(t *testing.T) {
+	tunnelID := uuid.New()
+	data, err := json.Marshal(connection.TunnelToken{
+		AccountTag:   "test-account",
+		TunnelSecret: []byte("test-secret-test-secret-test-sec"),
+		TunnelID:     tunnelID,
+		Endpoint:     "fed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := base64.StdEncoding.EncodeToString([]byte("test-secret-test-secret-test-sec"))
+
+	result, err := ValidateToken(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(result), &fields); err != nil {
+		t.Fatalf("invalid ValidateToken JSON %q: %v", result, err)
+	}
+	want := map[string]interface{}{
+		"tunnelId":       tunnelID.String(),
+		"accountTag":     "test-account",
+		"endpoint":       "fed",
+		"region":         "fed",
+		"uptimeSeconds":  float64(0),
+		"reconnectCount": float64(0),
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %v, want %v", key, fields[key], value)
+		}
+	}
+	if strings.Contains(result, secret) || strings.Contains(strings.ToLower(result), "secret") {
+		t.Errorf("ValidateToken leaks the tunnel secret: %s", result)
+	}
+
+	for _, token := range []string{"", "not-a-token", base64.StdEncoding.EncodeToString([]byte("{"))} {
+		if _, err := ValidateToken(token); classifyError(err) != ErrCodeInvalidToken {
+			t.Errorf("ValidateToken(%q) = %v, want InvalidToken", token, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/orchestration"
+)
+
+// Config sources reported by GetConfigSource
+const (
+	// ConfigSourceLocal means ingress rules were compiled from OriginURL/IngressRules
+	ConfigSourceLocal = "local"
+	// ConfigSourceRemote means ingress rules come from the Cloudflare dashboard
+	ConfigSourceRemote = "remote"
+)
+
+// IngressRule maps a hostname/path to a local service.
+// Service accepts the same values as cloudflared's config file
+// (e.g. "http://localhost:8080", "tcp://localhost:22", "http_status:404").
+type IngressRule struct {
+	Hostname string `json:"hostname,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Service  string `json:"service"`
+}
+
+// parseIngressRulesJSON decodes a JSON array of ingress rules
+func parseIngressRulesJSON(rulesJSON string) ([]IngressRule, error) {
+	var rules []IngressRule
+	if err := json.Unmarshal([]byte(rulesJSON), &rules); err != nil {
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("failed to parse ingress rules: %w", err))
+	}
+	for i, rule := range rules {
+		if rule.Service == "" {
+			return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("ingress rule #%d has no service", i+1))
+		}
+	}
+	return rules, nil
+}
+
+// buildIngress compiles the tunnel config into ingress rules.
+// If neither OriginURL nor IngressRules are set, an empty ingress is returned and the
+// tunnel waits for the dashboard configuration. Otherwise the rules are followed by a
+// catch-all pointing to OriginURL (or a 404 if no OriginURL is set).
+func buildIngress(cfg *TunnelConfig) (ingress.Ingress, string, error) {
+	if cfg.OriginURL == "" && len(cfg.IngressRules) == 0 {
+		return ingress.Ingress{}, ConfigSourceRemote, nil
+	}
+
+	rules := make([]config.UnvalidatedIngressRule, 0, len(cfg.IngressRules)+1)
+	for _, rule := range cfg.IngressRules {
+		rules = append(rules, config.UnvalidatedIngressRule{
+			Hostname: rule.Hostname,
+			Path:     rule.Path,
+			Service:  rule.Service,
+		})
+	}
+
+	catchAll := "http_status:404"
+	if cfg.OriginURL != "" {
+		catchAll = cfg.OriginURL
+	}
+	rules = append(rules, config.UnvalidatedIngressRule{Service: catchAll})
+
+	ingressRules, err := ingress.ParseIngress(&config.Configuration{Ingress: rules})
+	if err != nil {
+		return ingress.Ingress{}, "", newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid ingress rules: %w", err))
+	}
+	return ingressRules, ConfigSourceLocal, nil
+}
+
+// SetIngressRules replaces the hostname/path rules with a JSON array of
+// {"hostname", "path", "service"} objects. Takes effect on the next Start.
+func (t *Tunnel) SetIngressRules(rulesJSON string) error {
+	rules, err := parseIngressRulesJSON(rulesJSON)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.config.IngressRules = rules
+	t.mu.Unlock()
+	return nil
+}
+
+// AddIngressRule appends a single hostname/path rule. Takes effect on the next Start.
+func (t *Tunnel) AddIngressRule(hostname string, path string, service string) error {
+	if service == "" {
+		return newTunnelError(ErrCodeInvalidConfig, errors.New("ingress rule has no service"))
+	}
+	t.mu.Lock()
+	t.config.IngressRules = append(t.config.IngressRules, IngressRule{
+		Hostname: hostname,
+		Path:     path,
+		Service:  service,
+	})
+	t.mu.Unlock()
+	return nil
+}
+
+// GetConfigSource returns where the active ingress rules come from ("local" or "remote")
+func (t *Tunnel) GetConfigSource() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.configSource
+}
+
+// remoteConfigPollInterval is how often a running tunnel checks its
+// orchestrator for a configuration pushed from the dashboard
+const remoteConfigPollInterval = time.Second
+
+// watchRemoteConfig reports each configuration the edge pushes to the
+// orchestrator until done is closed. The orchestrator starts at version -1
+// with the local ingress; remote configurations are versioned from 0.
+func (t *Tunnel) watchRemoteConfig(orchestrator *orchestration.Orchestrator, done <-chan struct{}) {
+	ticker := time.NewTicker(remoteConfigPollInterval)
+	defer ticker.Stop()
+
+	applied := int32(-1)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		version, err := remoteConfigVersion(orchestrator)
+		if err != nil {
+			t.logCallback(1, "[config] Reading the orchestrator config failed: %v", err)
+			continue
+		}
+		if version <= applied {
+			continue
+		}
+		applied = version
+		t.remoteConfigApplied(version)
+	}
+}
+
+// remoteConfigVersion returns the version of the orchestrator's active configuration
+func remoteConfigVersion(orchestrator *orchestration.Orchestrator) (int32, error) {
+	data, err := orchestrator.GetVersionedConfigJSON()
+	if err != nil {
+		return 0, err
+	}
+	var versioned struct {
+		Version int32 `json:"version"`
+	}
+	if err := json.Unmarshal(data, &versioned); err != nil {
+		return 0, fmt.Errorf("invalid versioned config: %w", err)
+	}
+	return versioned.Version, nil
+}
+
+// remoteConfigApplied marks the ingress as remotely managed and notifies the callback
+func (t *Tunnel) remoteConfigApplied(version int32) {
+	t.mu.Lock()
+	previous := t.configSource
+	t.configSource = ConfigSourceRemote
+	state := t.state
+	t.mu.Unlock()
+
+	t.logCallback(0, "[config] Remote configuration version %d applied", version)
+	if previous == ConfigSourceLocal {
+		t.notifyState(state, "Remote configuration overrides local ingress")
+	} else {
+		t.notifyState(state, "Remote configuration applied")
+	}
+}
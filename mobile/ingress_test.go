@@ -0,0 +1,112 @@
+package mobile
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBuildIngress(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   TunnelConfig
+		source   string
+		services []string
+		code     ErrorCode
+	}{
+		{
+			name:   "nothing configured",
+			source: ConfigSourceRemote,
+		},
+		{
+			name:     "origin URL only",
+			config:   TunnelConfig{OriginURL: "http://localhost:8080"},
+			source:   ConfigSourceLocal,
+			services: []string{"http://localhost:8080"},
+		},
+		{
+			name: "rules and origin URL catch-all",
+			config: TunnelConfig{
+				OriginURL: "http://localhost:8080",
+				IngressRules: []IngressRule{
+					{Hostname: "api.example.com", Service: "http://localhost:9000"},
+					{Hostname: "ssh.example.com", Service: "tcp://localhost:22"},
+				},
+			},
+			source:   ConfigSourceLocal,
+			services: []string{"http://localhost:9000", "tcp://localhost:22", "http://localhost:8080"},
+		},
+		{
+			name: "rules without origin URL",
+			config: TunnelConfig{
+				IngressRules: []IngressRule{{Hostname: "api.example.com", Path: "/v1", Service: "http://localhost:9000"}},
+			},
+			source:   ConfigSourceLocal,
+			services: []string{"http://localhost:9000", "http_status:404"},
+		},
+		{
+			name: "invalid service",
+			config: TunnelConfig{
+				IngressRules: []IngressRule{{Hostname: "api.example.com", Service: "localhost"}},
+			},
+			code: ErrCodeInvalidConfig,
+		},
+	}
+	for _, tt := range tests {
+		rules, source, err := buildIngress(&tt.config)
+		if tt.code != 0 {
+			var tunnelErr *TunnelError
+			if !errors.As(err, &tunnelErr) || tunnelErr.Code != tt.code {
+				t.Errorf("%s: err = %v, want code %s", tt.name, err, tt.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if source != tt.source {
+			t.Errorf("%s: source = %q, want %q", tt.name, source, tt.source)
+		}
+		var services []string
+		for _, rule := range rules.Rules {
+			services = append(services, rule.Service.String())
+		}
+		if !slices.Equal(services, tt.services) {
+			t.Errorf("%s: services = %v, want %v", tt.name, services, tt.services)
+		}
+	}
+}
+
+func TestRemoteConfigOverridesLocalIngress(t *testing.T) {
+	edge := newFakeEdge(t)
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{OriginURL: "http://localhost:8080"}, recorder)
+
+	startConnected(t, tunnel)
+	if got := tunnel.GetConfigSource(); got != ConfigSourceLocal {
+		t.Fatalf("config source = %q, want %q", got, ConfigSourceLocal)
+	}
+	// Rules changed while running apply to the next start only
+	if err := tunnel.AddIngressRule("api.example.com", "", "http://localhost:9000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The edge pushes the dashboard configuration through the orchestrator
+	orchestrators := edge.getOrchestrators()
+	if len(orchestrators) != 1 {
+		t.Fatalf("daemon started %d times, want once", len(orchestrators))
+	}
+	orchestrators[0].UpdateConfig(1, []byte(`{"ingress":[{"service":"http_status:503"}]}`))
+	if !waitFor(3*remoteConfigPollInterval, func() bool { return tunnel.GetConfigSource() == ConfigSourceRemote }) {
+		t.Fatalf("config source = %q after a remote update, want %q", tunnel.GetConfigSource(), ConfigSourceRemote)
+	}
+	if !waitFor(time.Second, func() bool {
+		return slices.ContainsFunc(recorder.getStates(), func(s recordedState) bool {
+			return s.Message == "Remote configuration overrides local ingress"
+		})
+	}) {
+		t.Errorf("no override notification in %v", recorder.getStates())
+	}
+}
@@ -0,0 +1,105 @@
+package mobile
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+const leakTestCycles = 300
+
+// runCycle starts the tunnel, checks it is serving and stops it
+func runCycle(tb testing.TB, tunnel *Tunnel, client *http.Client, graceful bool) {
+	tb.Helper()
+	handle := tunnel.StartAsync()
+	if err := handle.WaitConnected(5000); err != nil {
+		tb.Fatalf("tunnel did not connect: %v", err)
+	}
+
+	resp, err := client.Get(fmt.Sprintf("http://%s/ready", tunnel.GetMetricsAddress()))
+	if err != nil {
+		tb.Fatalf("metrics request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		tb.Fatalf("/ready returned %d", resp.StatusCode)
+	}
+
+	timeoutMs := 0
+	if graceful {
+		timeoutMs = 5000
+	}
+	stoppedGracefully, err := tunnel.StopWithTimeout(timeoutMs)
+	if err != nil {
+		tb.Fatalf("stop failed: %v", err)
+	}
+	if stoppedGracefully != graceful {
+		tb.Fatalf("stopped gracefully = %v, want %v", stoppedGracefully, graceful)
+	}
+	if !handle.IsStopped() {
+		tb.Fatal("StartAsync still running after StopWithTimeout returned")
+	}
+	if err := handle.WaitStopped(0); err != nil {
+		tb.Fatalf("tunnel stopped with error: %v", err)
+	}
+}
+
+func TestStartStopCyclesDoNotLeak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping start/stop cycles in short mode")
+	}
+
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{MetricsAddress: "127.0.0.1:0"}, nil)
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	// Warm up so lazily started runtime goroutines are part of the baseline
+	runCycle(t, tunnel, client, true)
+	if !waitFor(5*time.Second, func() bool { return edge.openConns() == 0 }) {
+		t.Fatalf("fake edge still has %d open connections after warm-up", edge.openConns())
+	}
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	baseGoroutines := runtime.NumGoroutine()
+	baseSockets := openSockets()
+
+	for i := 0; i < leakTestCycles; i++ {
+		runCycle(t, tunnel, client, i%2 == 0)
+		if n := tunnel.resources.goroutines.Load(); n != 0 {
+			t.Fatalf("cycle %d: %d tracked goroutines still running", i, n)
+		}
+		if n := tunnel.resources.sockets.Load(); n != 0 {
+			t.Fatalf("cycle %d: %d tracked sockets still open", i, n)
+		}
+	}
+
+	if !waitFor(5*time.Second, func() bool { return edge.openConns() == 0 }) {
+		t.Errorf("fake edge still has %d open connections", edge.openConns())
+	}
+	if !waitFor(5*time.Second, func() bool { return runtime.NumGoroutine() <= baseGoroutines }) {
+		var stacks bytes.Buffer
+		_ = pprof.Lookup("goroutine").WriteTo(&stacks, 1)
+		t.Errorf("goroutines grew from %d to %d over %d cycles:\n%s", baseGoroutines, runtime.NumGoroutine(), leakTestCycles, stacks.String())
+	}
+	if baseSockets >= 0 {
+		if !waitFor(5*time.Second, func() bool { return openSockets() <= baseSockets }) {
+			t.Errorf("open sockets grew from %d to %d over %d cycles", baseSockets, openSockets(), leakTestCycles)
+		}
+	}
+
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	const maxHeapGrowth = 16 << 20
+	if after.HeapAlloc > before.HeapAlloc+maxHeapGrowth {
+		t.Errorf("heap grew from %d to %d bytes over %d cycles", before.HeapAlloc, after.HeapAlloc, leakTestCycles)
+	}
+	if wrapperResources.goroutines.Load() != 0 || wrapperResources.sockets.Load() != 0 {
+		t.Errorf("GetRuntimeStats reports leftovers: %s", GetRuntimeStats())
+	}
+}
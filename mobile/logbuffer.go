@@ -0,0 +1,166 @@
+package mobile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultLogBufferSize is the number of log events kept in memory
+const DefaultLogBufferSize = 2000
+
+// Log event sources
+const (
+	LogSourceTunnel = "tunnel"
+	LogSourceServer = "server"
+)
+
+// logs keeps the most recent tunnel and server log events of the process
+var logs = newLogBuffer(DefaultLogBufferSize)
+
+type logEntry struct {
+	level zerolog.Level
+	event LogEvent
+}
+
+// logBuffer is a fixed-size ring of log events with increasing sequence numbers
+type logBuffer struct {
+	mu      sync.Mutex
+	entries []logEntry
+	next    int
+	full    bool
+	seq     int64
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{entries: make([]logEntry, size)}
+}
+
+// add stores an event, overwriting the oldest one when full, and returns it with its sequence number
+func (b *logBuffer) add(level zerolog.Level, event LogEvent) LogEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	event.Seq = b.seq
+	b.entries[b.next] = logEntry{level: level, event: event}
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+	return event
+}
+
+// ordered returns the stored entries from oldest to newest. Must be called with b.mu held.
+func (b *logBuffer) ordered() []logEntry {
+	if !b.full {
+		return append([]logEntry(nil), b.entries[:b.next]...)
+	}
+	ordered := make([]logEntry, 0, len(b.entries))
+	ordered = append(ordered, b.entries[b.next:]...)
+	return append(ordered, b.entries[:b.next]...)
+}
+
+// query returns up to limit events newer than sinceSeq at or above minLevel.
+// A limit of 0 or less returns all matching events.
+func (b *logBuffer) query(sinceSeq int64, minLevel zerolog.Level, limit int) []LogEvent {
+	b.mu.Lock()
+	entries := b.ordered()
+	b.mu.Unlock()
+
+	events := make([]LogEvent, 0)
+	for _, entry := range entries {
+		if entry.event.Seq <= sinceSeq || entry.level < minLevel {
+			continue
+		}
+		events = append(events, entry.event)
+		if limit > 0 && len(events) >= limit {
+			break
+		}
+	}
+	return events
+}
+
+// resize changes the capacity, keeping the newest events
+func (b *logBuffer) resize(size int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := b.ordered()
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	b.entries = make([]logEntry, size)
+	copy(b.entries, entries)
+	b.next = len(entries) % size
+	b.full = len(entries) == size
+}
+
+// clear removes all events. Sequence numbers keep increasing.
+func (b *logBuffer) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries = make([]logEntry, len(b.entries))
+	b.next = 0
+	b.full = false
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// GetTunnelLogs returns buffered log events as a JSON array. Only events with a
+// sequence number greater than sinceSeq and a level of at least minLevel are
+// returned, up to limit events (0 for no limit).
+func GetTunnelLogs(sinceSeq int64, minLevel string, limit int) (string, error) {
+	level, err := parseLogLevel(minLevel)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(logs.query(sinceSeq, level, limit))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ExportLogs writes all buffered log events to path, one JSON object per line
+func ExportLogs(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, event := range logs.query(0, zerolog.TraceLevel, 0) {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("failed to write log file: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write log file: %w", err)
+	}
+	return file.Close()
+}
+
+// SetLogBufferSize changes how many log events are kept in memory
+func SetLogBufferSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("invalid log buffer size: %d", size)
+	}
+	logs.resize(size)
+	return nil
+}
+
+// ClearTunnelLogs removes all buffered log events
+func ClearTunnelLogs() {
+	logs.clear()
+}
@@ -0,0 +1,138 @@
+package mobile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// addLogEvents adds one event per level to buffer, with messages "m1", "m2", ...
+func addLogEvents(buffer *logBuffer, levels ...zerolog.Level) {
+	for _, level := range levels {
+		buffer.add(level, LogEvent{Source: LogSourceTunnel, Level: level.String(), Message: fmt.Sprintf("m%d", buffer.seq+1)})
+	}
+}
+
+// eventMessages returns the messages of events
+func eventMessages(events []LogEvent) []string {
+	messages := make([]string, 0, len(events))
+	for _, event := range events {
+		messages = append(messages, event.Message)
+	}
+	return messages
+}
+
+func TestLogBufferWrapsAround(t *testing.T) {
+	const added = DefaultLogBufferSize + 500
+	buffer := newLogBuffer(DefaultLogBufferSize)
+	for i := 0; i < added; i++ {
+		addLogEvents(buffer, zerolog.InfoLevel)
+	}
+
+	events := buffer.query(0, zerolog.TraceLevel, 0)
+	if len(events) != DefaultLogBufferSize {
+		t.Fatalf("buffer holds %d events, want %d", len(events), DefaultLogBufferSize)
+	}
+	for i, event := range events {
+		if want := int64(added - DefaultLogBufferSize + 1 + i); event.Seq != want || event.Message != fmt.Sprintf("m%d", want) {
+			t.Fatalf("event %d = #%d %q, want #%d, oldest first", i, event.Seq, event.Message, want)
+		}
+	}
+
+	// Shrinking keeps the newest events; clearing keeps the sequence going
+	buffer.resize(3)
+	if got := eventMessages(buffer.query(0, zerolog.TraceLevel, 0)); !reflect.DeepEqual(got, []string{"m2498", "m2499", "m2500"}) {
+		t.Errorf("after resize: %v, want the newest 3", got)
+	}
+	buffer.clear()
+	addLogEvents(buffer, zerolog.InfoLevel)
+	if events := buffer.query(0, zerolog.TraceLevel, 0); len(events) != 1 || events[0].Seq != added+1 {
+		t.Errorf("after clear: %+v, want one event #%d", events, added+1)
+	}
+}
+
+func TestLogBufferQuery(t *testing.T) {
+	buffer := newLogBuffer(10)
+	addLogEvents(buffer, zerolog.DebugLevel, zerolog.InfoLevel, zerolog.WarnLevel, zerolog.ErrorLevel, zerolog.DebugLevel, zerolog.WarnLevel)
+
+	tests := []struct {
+		sinceSeq int64
+		minLevel zerolog.Level
+		limit    int
+		want     []string
+	}{
+		{0, zerolog.TraceLevel, 0, []string{"m1", "m2", "m3", "m4", "m5", "m6"}},
+		{4, zerolog.TraceLevel, 0, []string{"m5", "m6"}},
+		{6, zerolog.TraceLevel, 0, []string{}},
+		{0, zerolog.WarnLevel, 0, []string{"m3", "m4", "m6"}},
+		{3, zerolog.WarnLevel, 0, []string{"m4", "m6"}},
+		{0, zerolog.TraceLevel, 2, []string{"m1", "m2"}},
+		{1, zerolog.InfoLevel, 2, []string{"m2", "m3"}},
+	}
+	for _, tt := range tests {
+		if got := eventMessages(buffer.query(tt.sinceSeq, tt.minLevel, tt.limit)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("query(%d, %s, %d) = %v, want %v", tt.sinceSeq, tt.minLevel, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestGetTunnelLogsAndExport(t *testing.T) {
+	ClearTunnelLogs()
+	first := logs.add(zerolog.InfoLevel, LogEvent{Source: LogSourceTunnel, Level: "info", Message: "info line"})
+	logs.add(zerolog.ErrorLevel, LogEvent{Source: LogSourceServer, Level: "error", Message: "error line"})
+	logs.add(zerolog.DebugLevel, LogEvent{Source: LogSourceTunnel, Level: "debug", Message: "debug line"})
+
+	data, err := GetTunnelLogs(first.Seq-1, "info", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []LogEvent
+	if err := json.Unmarshal([]byte(data), &events); err != nil {
+		t.Fatalf("invalid logs JSON: %v", err)
+	}
+	if got := eventMessages(events); !reflect.DeepEqual(got, []string{"info line", "error line"}) {
+		t.Errorf("GetTunnelLogs = %v, want the info and error lines", got)
+	}
+	if _, err := GetTunnelLogs(0, "loud", 0); err == nil {
+		t.Error("GetTunnelLogs accepted an invalid level")
+	}
+
+	// ExportLogs writes every buffered event as one JSON object per line
+	path := filepath.Join(t.TempDir(), "tunnel.log")
+	if err := ExportLogs(path); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var exported []LogEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event LogEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q is not a log event: %v", scanner.Text(), err)
+		}
+		exported = append(exported, event)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := logs.query(0, zerolog.TraceLevel, 0); !reflect.DeepEqual(exported, want) {
+		t.Errorf("exported %+v, want %+v", exported, want)
+	}
+	if len(exported) != 3 || exported[1].Level != "error" || exported[1].Source != LogSourceServer || exported[1].Seq != first.Seq+1 {
+		t.Errorf("exported %+v, want the 3 added events with their sequence, level and source", exported)
+	}
+
+	if err := ExportLogs(filepath.Join(t.TempDir(), "missing", "tunnel.log")); err == nil {
+		t.Error("ExportLogs succeeded into a missing directory")
+	}
+}
@@ -0,0 +1,227 @@
+package mobile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultLogLevel is the minimum level forwarded to callbacks unless configured
+const DefaultLogLevel = "debug"
+
+// LogEventCallback receives structured log events as JSON
+type LogEventCallback interface {
+	OnLogEvent(eventJSON string)
+}
+
+// LogEvent is a structured log line from cloudflared, the mobile wrapper or the local server
+type LogEvent struct {
+	Seq      int64                  `json:"seq"`
+	Source   string                 `json:"source"`
+	TunnelID string                 `json:"tunnelId,omitempty"`
+	Level    string                 `json:"level"`
+	Time     string                 `json:"time"`
+	Message  string                 `json:"message"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+var (
+	// globalLogEventCallback and globalLogLevel are applied to tunnels started by the static API
+	globalLogEventCallback LogEventCallback
+	globalLogLevel         = DefaultLogLevel
+	logSettingsMu          sync.Mutex
+)
+
+// parseLogLevel parses a level name such as "debug", "info", "warn" or "error"
+func parseLogLevel(level string) (zerolog.Level, error) {
+	if level == "" {
+		level = DefaultLogLevel
+	}
+	parsed, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil || parsed == zerolog.NoLevel {
+		return zerolog.NoLevel, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid log level: %q", level))
+	}
+	return parsed, nil
+}
+
+// callbackLevel maps a zerolog level to the 0 (info), 1 (warning), 2 (error) scale of OnLog
+func callbackLevel(level zerolog.Level) int {
+	switch {
+	case level >= zerolog.ErrorLevel:
+		return 2
+	case level == zerolog.WarnLevel:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// wrapperLevel maps the OnLog scale used by logCallback to a zerolog level
+func wrapperLevel(level int) zerolog.Level {
+	switch level {
+	case 2:
+		return zerolog.ErrorLevel
+	case 1:
+		return zerolog.WarnLevel
+	default:
+		return zerolog.DebugLevel
+	}
+}
+
+// parseLogEvent decodes a zerolog JSON line into a LogEvent
+func parseLogEvent(level zerolog.Level, line []byte) LogEvent {
+	event := LogEvent{Source: LogSourceTunnel, Level: level.String()}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		event.Time = time.Now().Format(time.RFC3339)
+		event.Message = strings.TrimSpace(string(line))
+		return event
+	}
+
+	if v, ok := fields[zerolog.TimestampFieldName].(string); ok {
+		event.Time = v
+	} else {
+		event.Time = time.Now().Format(time.RFC3339)
+	}
+	if v, ok := fields[zerolog.MessageFieldName].(string); ok {
+		event.Message = v
+	}
+	delete(fields, zerolog.TimestampFieldName)
+	delete(fields, zerolog.MessageFieldName)
+	delete(fields, zerolog.LevelFieldName)
+	if len(fields) > 0 {
+		event.Fields = fields
+	}
+	return event
+}
+
+// String formats the event as "message key=value ..." for OnLog
+func (e LogEvent) String() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(e.Message)
+	for _, key := range keys {
+		fmt.Fprintf(&sb, " %s=%v", key, e.Fields[key])
+	}
+	return sb.String()
+}
+
+// logEnabled reports whether a level passes the tunnel's minimum level
+func (t *Tunnel) logEnabled(level zerolog.Level) bool {
+	return level >= zerolog.Level(t.minLogLevel.Load())
+}
+
+// emitLogEvent stores an event in the log buffer and, if it passes the minimum
+// level, delivers it to OnLog and OnLogEvent. The buffer keeps filtered events
+// too, so GetTunnelLogs can return debug lines the callbacks didn't receive.
+func (t *Tunnel) emitLogEvent(level zerolog.Level, event LogEvent) {
+	event.TunnelID = t.id
+	event = logs.add(level, event)
+	if level != zerolog.NoLevel && !t.logEnabled(level) {
+		return
+	}
+
+	if t.callback != nil {
+		t.callback.OnLog(callbackLevel(level), event.String())
+	}
+
+	t.mu.RLock()
+	callback := t.logEventCallback
+	t.mu.RUnlock()
+	if callback == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	callback.OnLogEvent(string(data))
+}
+
+// SetLogLevel sets the minimum level of events forwarded to the callbacks
+func (t *Tunnel) SetLogLevel(level string) error {
+	parsed, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	t.minLogLevel.Store(int32(parsed))
+	return nil
+}
+
+// SetLogEventCallback sets the callback receiving structured log events
+func (t *Tunnel) SetLogEventCallback(callback LogEventCallback) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.logEventCallback = callback
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// SetLogEventCallback sets the structured log callback for the global tunnel,
+// including tunnels started later with the static API
+func SetLogEventCallback(callback LogEventCallback) {
+	logSettingsMu.Lock()
+	globalLogEventCallback = callback
+	logSettingsMu.Unlock()
+
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel != nil {
+		globalTunnel.SetLogEventCallback(callback)
+	}
+}
+
+// SetLogEventCallbackByID sets the structured log callback of a tunnel created with CreateTunnel
+func SetLogEventCallbackByID(id string, callback LogEventCallback) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	tunnel.SetLogEventCallback(callback)
+	return nil
+}
+
+// SetLogLevel sets the minimum log level ("debug", "info", "warn", "error") for the
+// global tunnel, including tunnels started later with the static API
+func SetLogLevel(level string) error {
+	if _, err := parseLogLevel(level); err != nil {
+		return err
+	}
+
+	logSettingsMu.Lock()
+	globalLogLevel = level
+	logSettingsMu.Unlock()
+
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel != nil {
+		return globalTunnel.SetLogLevel(level)
+	}
+	return nil
+}
+
+// SetLogLevelByID sets the minimum log level of a tunnel created with CreateTunnel
+func SetLogLevelByID(id string, level string) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	return tunnel.SetLogLevel(level)
+}
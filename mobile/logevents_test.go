@@ -0,0 +1,81 @@
+package mobile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// bufferedMessages returns the messages of the buffered events newer than sinceSeq
+func bufferedMessages(sinceSeq int64) []string {
+	var messages []string
+	for _, event := range logs.query(sinceSeq, zerolog.TraceLevel, 0) {
+		messages = append(messages, event.Message)
+	}
+	return messages
+}
+
+// lastSeq returns the sequence number of the newest buffered event
+func lastSeq() int64 {
+	events := logs.query(0, zerolog.TraceLevel, 0)
+	if len(events) == 0 {
+		return 0
+	}
+	return events[len(events)-1].Seq
+}
+
+// containsInOrder reports whether want appears in got in the same order
+func containsInOrder(got []string, want ...string) bool {
+	for _, message := range got {
+		if len(want) > 0 && message == want[0] {
+			want = want[1:]
+		}
+	}
+	return len(want) == 0
+}
+
+func TestFilteredLogEventsAreBuffered(t *testing.T) {
+	recorder := &callbackRecorder{}
+	tunnel, err := NewTunnel(testToken(t), "http://127.0.0.1:8080", recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tunnel.SetLogLevel("error"); err != nil {
+		t.Fatal(err)
+	}
+	since := lastSeq()
+	delivered := len(recorder.getLogs())
+
+	tunnel.logCallback(0, "wrapper debug line")
+	tunnel.log.Info().Msg("cloudflared info line")
+	tunnel.logCallback(2, "wrapper error line")
+
+	got := recorder.getLogs()[delivered:]
+	if len(got) != 1 || !strings.HasPrefix(got[0], "wrapper error line") {
+		t.Errorf("OnLog received %q, want only the error line", got)
+	}
+	if messages := bufferedMessages(since); !containsInOrder(messages, "wrapper debug line", "cloudflared info line", "wrapper error line") {
+		t.Errorf("buffered messages = %q, want the filtered lines too", messages)
+	}
+}
+
+func TestConstructorLogsAreBuffered(t *testing.T) {
+	since := lastSeq()
+	if _, err := NewTunnel(testToken(t), "http://127.0.0.1:8080", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewQuickTunnel("http://127.0.0.1:8080", nil); err != nil {
+		t.Fatal(err)
+	}
+	id, err := CreateTunnel(`{"token":"`+testToken(t)+`"}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = RemoveTunnel(id) }()
+
+	messages := bufferedMessages(since)
+	if !containsInOrder(messages, "[NewTunnel] Creating tunnel instance", "[NewQuickTunnel] Creating quick tunnel instance", "[CreateTunnel] Created tunnel "+id) {
+		t.Errorf("buffered messages = %q, want the constructor logs", messages)
+	}
+}
@@ -0,0 +1,377 @@
+package mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Metric names registered by cloudflared that make up the snapshot
+const (
+	metricTotalRequests = "cloudflared_tunnel_total_requests"
+	metricRequestErrors = "cloudflared_tunnel_request_errors"
+	metricActiveStreams = "cloudflared_tunnel_concurrent_requests_per_tunnel"
+	metricSentBytes     = "quic_client_sent_bytes"
+	metricReceivedBytes = "quic_client_receive_bytes"
+	metricSmoothedRTT   = "quic_client_smoothed_rtt"
+	metricMinRTT        = "quic_client_min_rtt"
+	metricLatestRTT     = "quic_client_latest_rtt"
+	connIndexLabel      = "conn_index"
+)
+
+// Metric names of the collectors the wrapper keeps for each tunnel
+const (
+	metricRegistrations = "cloudflared_mobile_connection_registrations_total"
+	metricReconnects    = "cloudflared_mobile_reconnects_total"
+	metricHAConnections = "cloudflared_mobile_ha_connections"
+	tunnelIDLabel       = "tunnel_id"
+)
+
+// tunnelMetrics are the collectors the wrapper keeps for one tunnel. They live
+// as long as the Tunnel, so they keep counting across restarts.
+type tunnelMetrics struct {
+	registrations prometheus.Counter
+	reconnects    prometheus.Counter
+	haConnections prometheus.GaugeFunc
+}
+
+// newTunnelMetrics creates the collectors of tunnel t
+func newTunnelMetrics(t *Tunnel) *tunnelMetrics {
+	return &tunnelMetrics{
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricRegistrations,
+			Help: "Edge connections registered by this tunnel",
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: metricReconnects,
+			Help: "HA connections of this tunnel that registered again after a disconnect",
+		}),
+		haConnections: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: metricHAConnections,
+			Help: "HA connections of this tunnel currently registered with the edge",
+		}, func() float64 {
+			return float64(t.readyConnections())
+		}),
+	}
+}
+
+// collectors returns the collectors to register for a run
+func (m *tunnelMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.registrations, m.reconnects, m.haConnections}
+}
+
+// runRegisterer registers collectors with a tunnel's registry for one run and
+// unregisters them when the run ends. cloudflared creates new collectors on
+// every start, so the next run can register its own without a duplicate.
+type runRegisterer struct {
+	prometheus.Registerer
+
+	mu         sync.Mutex
+	registered []prometheus.Collector
+}
+
+// newRunRegisterer returns a registerer that labels every series with the tunnel ID
+func newRunRegisterer(registry *prometheus.Registry, tunnelID string) *runRegisterer {
+	return &runRegisterer{
+		Registerer: prometheus.WrapRegistererWith(prometheus.Labels{tunnelIDLabel: tunnelID}, registry),
+	}
+}
+
+func (r *runRegisterer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.registered = append(r.registered, c)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *runRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *runRegisterer) Unregister(c prometheus.Collector) bool {
+	r.mu.Lock()
+	for i, registered := range r.registered {
+		if registered == c {
+			r.registered = append(r.registered[:i], r.registered[i+1:]...)
+			break
+		}
+	}
+	r.mu.Unlock()
+	return r.Registerer.Unregister(c)
+}
+
+// unregisterAll unregisters everything registered during the run
+func (r *runRegisterer) unregisterAll() {
+	r.mu.Lock()
+	registered := r.registered
+	r.registered = nil
+	r.mu.Unlock()
+
+	for _, c := range registered {
+		r.Registerer.Unregister(c)
+	}
+}
+
+var (
+	// runningTunnels are the tunnels with a run in progress. cloudflared's
+	// process-wide collectors are shared by all of them.
+	runningTunnels   = make(map[*Tunnel]struct{})
+	runningTunnelsMu sync.Mutex
+)
+
+// setRunning records whether the tunnel has a run in progress
+func (t *Tunnel) setRunning(running bool) {
+	runningTunnelsMu.Lock()
+	defer runningTunnelsMu.Unlock()
+	if running {
+		runningTunnels[t] = struct{}{}
+	} else {
+		delete(runningTunnels, t)
+	}
+}
+
+// runningTunnelCount returns the number of tunnels with a run in progress
+func runningTunnelCount() int {
+	runningTunnelsMu.Lock()
+	defer runningTunnelsMu.Unlock()
+	return len(runningTunnels)
+}
+
+// labelValue returns the value of the named label of a metric
+func labelValue(m *dto.Metric, name string) (string, bool) {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue(), true
+		}
+	}
+	return "", false
+}
+
+// MetricsSnapshot is the compact JSON returned by GetTunnelMetrics.
+//
+// Registrations, Reconnects and HAConnections belong to this tunnel. The other
+// counters and Connections come from collectors cloudflared registers once per
+// process, so they are totals across all tunnels running in the process;
+// RunningTunnels says how many that is. Connections are keyed by HA connection
+// index, which concurrent tunnels share.
+type MetricsSnapshot struct {
+	Timestamp      string              `json:"timestamp"`
+	Registrations  int64               `json:"registrations"`
+	Reconnects     int64               `json:"reconnects"`
+	HAConnections  int64               `json:"haConnections"`
+	RunningTunnels int                 `json:"runningTunnels"`
+	TotalRequests  int64               `json:"totalRequests"`
+	RequestErrors  int64               `json:"requestErrors"`
+	ActiveStreams  int64               `json:"activeStreams"`
+	BytesSent      int64               `json:"bytesSent"`
+	BytesReceived  int64               `json:"bytesReceived"`
+	Connections    []ConnectionMetrics `json:"connections"`
+	Server         *ServerMetrics      `json:"server,omitempty"`
+}
+
+// ConnectionMetrics holds the per-connection QUIC counters (RTTs in milliseconds)
+type ConnectionMetrics struct {
+	Index         int     `json:"index"`
+	SmoothedRTTMs float64 `json:"smoothedRttMs"`
+	MinRTTMs      float64 `json:"minRttMs"`
+	LatestRTTMs   float64 `json:"latestRttMs"`
+	BytesSent     int64   `json:"bytesSent"`
+	BytesReceived int64   `json:"bytesReceived"`
+}
+
+// gatherer returns the tunnel's own registry plus the process-wide one, which
+// holds the collectors cloudflared shares between all tunnels
+func (t *Tunnel) gatherer() prometheus.Gatherer {
+	return prometheus.Gatherers{t.registry, prometheus.DefaultGatherer}
+}
+
+// gatherMetrics collects the metric families, tolerating partial results
+func (t *Tunnel) gatherMetrics() ([]*dto.MetricFamily, error) {
+	families, err := t.gatherer().Gather()
+	if err != nil && len(families) == 0 {
+		return nil, fmt.Errorf("failed to gather metrics: %w", err)
+	}
+	return families, nil
+}
+
+// GetMetrics returns a compact JSON snapshot of the tunnel metrics. While other
+// tunnels run in the same process, cloudflared's shared counters (requests,
+// errors, streams, QUIC bytes and RTTs) are totals across them; see
+// MetricsSnapshot.
+func (t *Tunnel) GetMetrics() (string, error) {
+	families, err := t.gatherMetrics()
+	if err != nil {
+		return "", err
+	}
+
+	snapshot := buildMetricsSnapshot(families)
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// GetMetricsText returns the tunnel metrics in Prometheus text format. Series
+// from the tunnel's own registry carry a tunnel_id label; cloudflared's
+// process-wide series don't.
+func (t *Tunnel) GetMetricsText() (string, error) {
+	families, err := t.gatherMetrics()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&sb, family); err != nil {
+			return "", fmt.Errorf("failed to encode metrics: %w", err)
+		}
+	}
+	return sb.String(), nil
+}
+
+// buildMetricsSnapshot extracts the snapshot fields from gathered metric families
+func buildMetricsSnapshot(families []*dto.MetricFamily) *MetricsSnapshot {
+	snapshot := &MetricsSnapshot{
+		Timestamp:      time.Now().Format(time.RFC3339),
+		RunningTunnels: runningTunnelCount(),
+		Connections:    make([]ConnectionMetrics, 0),
+	}
+	connections := make(map[int]*ConnectionMetrics)
+	connection := func(m *dto.Metric) *ConnectionMetrics {
+		value, _ := labelValue(m, connIndexLabel)
+		index, err := strconv.Atoi(value)
+		if err != nil || index < 0 {
+			return nil
+		}
+		if _, ok := connections[index]; !ok {
+			connections[index] = &ConnectionMetrics{Index: index}
+		}
+		return connections[index]
+	}
+
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			value := metricValue(m)
+			switch family.GetName() {
+			case metricTotalRequests:
+				snapshot.TotalRequests += int64(value)
+			case metricRequestErrors:
+				snapshot.RequestErrors += int64(value)
+			case metricActiveStreams:
+				snapshot.ActiveStreams += int64(value)
+			case metricRegistrations:
+				snapshot.Registrations += int64(value)
+			case metricReconnects:
+				snapshot.Reconnects += int64(value)
+			case metricHAConnections:
+				snapshot.HAConnections += int64(value)
+			case metricSentBytes:
+				snapshot.BytesSent += int64(value)
+				if c := connection(m); c != nil {
+					c.BytesSent += int64(value)
+				}
+			case metricReceivedBytes:
+				snapshot.BytesReceived += int64(value)
+				if c := connection(m); c != nil {
+					c.BytesReceived += int64(value)
+				}
+			case metricSmoothedRTT:
+				if c := connection(m); c != nil {
+					c.SmoothedRTTMs = value
+				}
+			case metricMinRTT:
+				if c := connection(m); c != nil {
+					c.MinRTTMs = value
+				}
+			case metricLatestRTT:
+				if c := connection(m); c != nil {
+					c.LatestRTTMs = value
+				}
+			}
+		}
+	}
+
+	for _, c := range connections {
+		snapshot.Connections = append(snapshot.Connections, *c)
+	}
+	sort.Slice(snapshot.Connections, func(i, j int) bool {
+		return snapshot.Connections[i].Index < snapshot.Connections[j].Index
+	})
+
+	serverMu.Lock()
+	if globalServer != nil {
+		serverMetrics := globalServer.GetMetrics()
+		snapshot.Server = &serverMetrics
+	}
+	serverMu.Unlock()
+
+	return snapshot
+}
+
+// metricValue returns the value of a counter, gauge or untyped metric
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	case m.GetUntyped() != nil:
+		return m.GetUntyped().GetValue()
+	default:
+		return 0
+	}
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// GetTunnelMetrics returns a JSON snapshot of the running tunnel's metrics
+func GetTunnelMetrics() (string, error) {
+	tunnelMu.Lock()
+	tunnel := globalTunnel
+	tunnelMu.Unlock()
+
+	if tunnel == nil {
+		return "", errors.New("no tunnel is running")
+	}
+	return tunnel.GetMetrics()
+}
+
+// GetTunnelMetricsText returns the running tunnel's metrics in Prometheus text format
+func GetTunnelMetricsText() (string, error) {
+	tunnelMu.Lock()
+	tunnel := globalTunnel
+	tunnelMu.Unlock()
+
+	if tunnel == nil {
+		return "", errors.New("no tunnel is running")
+	}
+	return tunnel.GetMetricsText()
+}
+
+// GetTunnelMetricsByID returns a JSON snapshot of the metrics of a tunnel created with CreateTunnel
+func GetTunnelMetricsByID(id string) (string, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return "", err
+	}
+	return tunnel.GetMetrics()
+}
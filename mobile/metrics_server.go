@@ -0,0 +1,152 @@
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const metricsShutdownTimeout = 5 * time.Second
+
+// readyResponse is served on /ready, matching cloudflared's metrics server
+type readyResponse struct {
+	Status           int    `json:"status"`
+	ReadyConnections uint   `json:"readyConnections"`
+	ConnectorID      string `json:"connectorId"`
+}
+
+// quickTunnelInfoResponse is served on /quicktunnel
+type quickTunnelInfoResponse struct {
+	Hostname string `json:"hostname"`
+}
+
+// validateMetricsAddress ensures the metrics listener is only reachable from the device
+func validateMetricsAddress(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid metrics address %q: %w", addr, err))
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("metrics address %q must be a loopback address", addr))
+	}
+	return nil
+}
+
+// metricsHandler serves /metrics, /ready, /healthcheck and /quicktunnel
+func (t *Tunnel) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		text, err := t.GetMetricsText()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(text))
+	})
+
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		t.mu.RLock()
+		connectorID := t.connectorID
+		t.mu.RUnlock()
+
+		resp := readyResponse{
+			Status:           http.StatusOK,
+			ReadyConnections: t.readyConnections(),
+			ConnectorID:      connectorID,
+		}
+		if resp.ReadyConnections == 0 {
+			resp.Status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.Status)
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+
+	mux.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK\n"))
+	})
+
+	mux.HandleFunc("/quicktunnel", func(w http.ResponseWriter, r *http.Request) {
+		t.mu.RLock()
+		hostname := t.quickTunnelHostname
+		t.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(quickTunnelInfoResponse{Hostname: hostname})
+	})
+
+	return mux
+}
+
+// startMetricsServer starts the loopback metrics listener. The returned function
+// shuts it down.
+func (t *Tunnel) startMetricsServer(addr string) (stop func(), err error) {
+	if err := validateMetricsAddress(addr); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("failed to start metrics server: %w", err))
+	}
+	listener = t.trackListener(listener)
+
+	server := &http.Server{
+		Handler:      t.metricsHandler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	t.mu.Lock()
+	t.metricsAddr = listener.Addr().String()
+	t.mu.Unlock()
+
+	t.goTracked(func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.logCallback(2, "[metrics] Server error: %v", err)
+		}
+	})
+	t.logCallback(0, "[metrics] Serving metrics on http://%s", listener.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+
+		t.mu.Lock()
+		t.metricsAddr = ""
+		t.mu.Unlock()
+	}, nil
+}
+
+// GetMetricsAddress returns the address of the metrics listener, or an empty
+// string if it is not running
+func (t *Tunnel) GetMetricsAddress() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.metricsAddr
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// GetTunnelMetricsAddress returns the metrics listener address of the running tunnel
+func GetTunnelMetricsAddress() string {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel == nil {
+		return ""
+	}
+	return globalTunnel.GetMetricsAddress()
+}
@@ -0,0 +1,102 @@
+package mobile
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getMetricsEndpoint fetches path from the tunnel's metrics listener
+func getMetricsEndpoint(t *testing.T, tunnel *Tunnel, path string) (int, string) {
+	t.Helper()
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + tunnel.GetMetricsAddress() + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsServerEndpoints(t *testing.T) {
+	// Not ready until a connection is registered
+	held := newFakeEdge(t)
+	held.holdRegistrations()
+	connecting := newFakeEdgeTunnel(t, held, &TunnelConfig{MetricsAddress: "127.0.0.1:0"}, nil)
+	connecting.StartAsync()
+	t.Cleanup(func() { _, _ = connecting.StopWithTimeout(0) })
+	if !waitFor(5*time.Second, func() bool { return connecting.GetMetricsAddress() != "" }) {
+		t.Fatal("metrics server did not start")
+	}
+	if status, body := getMetricsEndpoint(t, connecting, "/ready"); status != http.StatusServiceUnavailable {
+		t.Errorf("/ready while connecting = %d %s, want 503", status, body)
+	}
+	if status, body := getMetricsEndpoint(t, connecting, "/healthcheck"); status != http.StatusOK || body != "OK\n" {
+		t.Errorf("/healthcheck = %d %q, want 200 OK", status, body)
+	}
+
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{MetricsAddress: "127.0.0.1:0"}, nil)
+	startConnected(t, tunnel)
+
+	status, body := getMetricsEndpoint(t, tunnel, "/ready")
+	var ready readyResponse
+	if err := json.Unmarshal([]byte(body), &ready); err != nil {
+		t.Fatalf("invalid /ready response %q: %v", body, err)
+	}
+	if status != http.StatusOK || ready.Status != http.StatusOK || ready.ReadyConnections != 2 || ready.ConnectorID != tunnelInfo(t, tunnel).ConnectorID {
+		t.Errorf("/ready = %d %+v, want 200 with 2 connections and the connector ID", status, ready)
+	}
+	if status, body := getMetricsEndpoint(t, tunnel, "/metrics"); status != http.StatusOK || !strings.Contains(body, "# TYPE ") {
+		t.Errorf("/metrics = %d %q, want Prometheus text", status, body)
+	}
+	status, body = getMetricsEndpoint(t, tunnel, "/quicktunnel")
+	var quick quickTunnelInfoResponse
+	if err := json.Unmarshal([]byte(body), &quick); err != nil || status != http.StatusOK || quick.Hostname != "" {
+		t.Errorf("/quicktunnel = %d %q, want 200 without a hostname", status, body)
+	}
+
+	// The listener goes away with the run
+	addr := tunnel.GetMetricsAddress()
+	if _, err := tunnel.StopWithTimeout(5000); err != nil {
+		t.Fatal(err)
+	}
+	if tunnel.GetMetricsAddress() != "" {
+		t.Error("metrics address still reported after stop")
+	}
+	if _, err := http.Get("http://" + addr + "/healthcheck"); err == nil {
+		t.Error("metrics server still serving after stop")
+	}
+}
+
+func TestValidateMetricsAddress(t *testing.T) {
+	tests := []struct {
+		addr  string
+		valid bool
+	}{
+		{"127.0.0.1:0", true},
+		{"127.0.0.1:2000", true},
+		{"[::1]:2000", true},
+		{"localhost:2000", true},
+		{"0.0.0.0:2000", false},
+		{"192.168.1.10:2000", false},
+		{"example.com:2000", false},
+		{"127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		err := validateMetricsAddress(tt.addr)
+		if tt.valid && err != nil {
+			t.Errorf("validateMetricsAddress(%q) = %v, want nil", tt.addr, err)
+		}
+		if !tt.valid && classifyError(err) != ErrCodeInvalidConfig {
+			t.Errorf("validateMetricsAddress(%q) = %v, want InvalidConfig", tt.addr, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package mobile
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsKeepCountingAcrossRestarts(t *testing.T) {
+	defaultRegisterer := prometheus.DefaultRegisterer
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+
+	startConnected(t, tunnel)
+	if snapshot := metricsSnapshot(t, tunnel); snapshot.Registrations != 2 || snapshot.HAConnections != 2 {
+		t.Errorf("first run: registrations = %d, haConnections = %d; want 2 and 2", snapshot.Registrations, snapshot.HAConnections)
+	}
+	if _, err := tunnel.StopWithTimeout(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second run registers cloudflared's collectors again without a duplicate
+	startConnected(t, tunnel)
+	snapshot := metricsSnapshot(t, tunnel)
+	if snapshot.Registrations != 4 || snapshot.HAConnections != 2 {
+		t.Errorf("second run: registrations = %d, haConnections = %d; want 4 and 2", snapshot.Registrations, snapshot.HAConnections)
+	}
+	text, err := tunnel.GetMetricsText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(text, "\ncloudflared_origins_dns_resolver_refreshes{"); count != 1 {
+		t.Errorf("DNS resolver metric appears %d times, want once:\n%s", count, text)
+	}
+	if prometheus.DefaultRegisterer != defaultRegisterer {
+		t.Error("the default registerer was replaced")
+	}
+}
+
+func TestConcurrentTunnelMetrics(t *testing.T) {
+	sent := prometheus.NewCounterVec(prometheus.CounterOpts{Name: metricSentBytes, Help: "test"}, []string{connIndexLabel})
+	prometheus.MustRegister(sent)
+	defer prometheus.Unregister(sent)
+	sent.WithLabelValues("0").Add(100)
+	sent.WithLabelValues("1").Add(20)
+
+	edge := newFakeEdge(t)
+	first := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+	second := newFakeEdgeTunnel(t, edge, &TunnelConfig{HAConnections: 1}, nil)
+	startConnected(t, first)
+	startConnected(t, second)
+
+	// Each tunnel counts its own connections
+	if snapshot := metricsSnapshot(t, first); snapshot.Registrations != 2 || snapshot.HAConnections != 2 {
+		t.Errorf("first tunnel: registrations = %d, haConnections = %d; want 2 and 2", snapshot.Registrations, snapshot.HAConnections)
+	}
+	if snapshot := metricsSnapshot(t, second); snapshot.Registrations != 1 || snapshot.HAConnections != 1 {
+		t.Errorf("second tunnel: registrations = %d, haConnections = %d; want 1 and 1", snapshot.Registrations, snapshot.HAConnections)
+	}
+
+	// cloudflared's process-wide counters are totals across both
+	for _, tunnel := range []*Tunnel{first, second} {
+		if snapshot := metricsSnapshot(t, tunnel); snapshot.BytesSent != 120 || snapshot.RunningTunnels != 2 {
+			t.Errorf("bytesSent = %d, runningTunnels = %d; want 120 and 2", snapshot.BytesSent, snapshot.RunningTunnels)
+		}
+	}
+
+	text, err := first.GetMetricsText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, first.tunnelLabel()) || strings.Contains(text, second.tunnelLabel()) {
+		t.Errorf("first tunnel's metrics are not labelled with its own tunnel ID only:\n%s", text)
+	}
+
+	if _, err := second.StopWithTimeout(0); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot := metricsSnapshot(t, first); snapshot.RunningTunnels != 1 || snapshot.HAConnections != 2 {
+		t.Errorf("after the second tunnel stopped: runningTunnels = %d, haConnections = %d; want 1 and 2", snapshot.RunningTunnels, snapshot.HAConnections)
+	}
+}
+
+// tunnelLabel returns the tunnel_id label pair of tunnel's series
+func (t *Tunnel) tunnelLabel() string {
+	token, err := parseToken(t.config.Token)
+	if err != nil {
+		panic(err)
+	}
+	return tunnelIDLabel + `="` + token.TunnelID.String() + `"`
+}
+
+// metricsSnapshot returns the decoded GetMetrics of tunnel
+func metricsSnapshot(t *testing.T, tunnel *Tunnel) MetricsSnapshot {
+	t.Helper()
+	data, err := tunnel.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics: %v", err)
+	}
+	var snapshot MetricsSnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		t.Fatalf("invalid metrics snapshot: %v", err)
+	}
+	return snapshot
+}
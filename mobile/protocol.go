@@ -0,0 +1,108 @@
+package mobile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+)
+
+// Protocol selection values for TunnelConfig.Protocol
+const (
+	// ProtocolAuto starts with QUIC and falls back to HTTP/2 when QUIC keeps failing
+	ProtocolAuto = connection.AutoSelectFlag
+	// ProtocolQUIC always uses QUIC (UDP 7844)
+	ProtocolQUIC = "quic"
+	// ProtocolHTTP2 always uses HTTP/2 over TCP 7844
+	ProtocolHTTP2 = "http2"
+)
+
+// parseProtocol validates a protocol setting, defaulting to auto
+func parseProtocol(protocol string, postQuantum bool) (string, error) {
+	switch p := strings.ToLower(strings.TrimSpace(protocol)); p {
+	case "":
+		return ProtocolAuto, nil
+	case ProtocolAuto, ProtocolQUIC:
+		return p, nil
+	case ProtocolHTTP2:
+		if postQuantum {
+			return "", newTunnelError(ErrCodeInvalidConfig, errors.New("post-quantum requires the quic protocol"))
+		}
+		return p, nil
+	default:
+		return "", newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid protocol: %q (expected auto, quic or http2)", protocol))
+	}
+}
+
+// newProtocolSelector builds cloudflared's selector for a parsed protocol
+// setting. Auto starts with QUIC and lets the supervisor fall back to HTTP/2.
+// The percentage fetcher is stubbed to avoid a DNS lookup on mobile.
+func newProtocolSelector(protocol string, accountTag string, hasToken bool, postQuantum bool, log *zerolog.Logger) (connection.ProtocolSelector, error) {
+	return connection.NewProtocolSelector(
+		protocol,
+		accountTag,
+		hasToken,
+		postQuantum,
+		func() (edgediscovery.ProtocolPercents, error) {
+			// Return default protocol percentages to avoid DNS lookup issues on mobile
+			return edgediscovery.ProtocolPercents{
+				{Protocol: "quic", Percentage: 100},
+			}, nil
+		},
+		connection.ResolveTTL,
+		log,
+	)
+}
+
+// observeProtocol records the protocol of a registered connection and reports
+// when the supervisor switches protocols, e.g. falling back from QUIC to HTTP/2
+func (t *Tunnel) observeProtocol(protocol connection.Protocol) {
+	name := protocol.String()
+
+	t.mu.Lock()
+	previous := t.activeProtocol
+	t.activeProtocol = name
+	state := t.state
+	t.mu.Unlock()
+
+	if previous == "" || previous == name {
+		return
+	}
+	t.logCallback(1, "[protocol] Switched from %s to %s", previous, name)
+	t.notifyState(state, fmt.Sprintf("Protocol changed from %s to %s", previous, name))
+}
+
+// GetProtocol returns the protocol of the edge connections ("quic" or "http2"),
+// or an empty string if no connection has been registered yet
+func (t *Tunnel) GetProtocol() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.activeProtocol
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// GetTunnelProtocol returns the protocol used by the global tunnel
+func GetTunnelProtocol() string {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel == nil {
+		return ""
+	}
+	return globalTunnel.GetProtocol()
+}
+
+// GetTunnelProtocolByID returns the protocol used by a tunnel created with CreateTunnel
+func GetTunnelProtocolByID(id string) (string, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return "", err
+	}
+	return tunnel.GetProtocol(), nil
+}
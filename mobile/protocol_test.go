@@ -0,0 +1,67 @@
+package mobile
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestProtocolSelectorFallback(t *testing.T) {
+	log := zerolog.Nop()
+	tests := []struct {
+		setting     string
+		current     connection.Protocol
+		hasFallback bool
+	}{
+		{"", connection.QUIC, true},
+		{"auto", connection.QUIC, true},
+		{"quic", connection.QUIC, false},
+		{"HTTP2", connection.HTTP2, false},
+	}
+	for _, tt := range tests {
+		protocol, err := parseProtocol(tt.setting, false)
+		if err != nil {
+			t.Fatalf("parseProtocol(%q): %v", tt.setting, err)
+		}
+		selector, err := newProtocolSelector(protocol, "test-account", true, false, &log)
+		if err != nil {
+			t.Fatalf("newProtocolSelector(%q): %v", protocol, err)
+		}
+		if got := selector.Current(); got != tt.current {
+			t.Errorf("%q: current protocol = %s, want %s", tt.setting, got, tt.current)
+		}
+		fallback, ok := selector.Fallback()
+		if ok != tt.hasFallback || (ok && fallback != connection.HTTP2) {
+			t.Errorf("%q: fallback = %s, %v; want HTTP/2 only when %v", tt.setting, fallback, ok, tt.hasFallback)
+		}
+	}
+}
+
+func TestProtocolAutoFallsBackToHTTP2(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.blockUDP()
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{Protocol: ProtocolAuto, Retries: 2}, nil)
+
+	startConnected(t, tunnel)
+	if got := tunnel.GetProtocol(); got != ProtocolHTTP2 {
+		t.Errorf("protocol = %q, want fallback to %s", got, ProtocolHTTP2)
+	}
+	if edge.getQUICAttempts() == 0 {
+		t.Error("tunnel never tried QUIC")
+	}
+}
+
+func TestProtocolQUICDoesNotFallBack(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.blockUDP()
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{Protocol: ProtocolQUIC, Retries: 1}, nil)
+
+	if err := tunnel.Start(); err == nil {
+		t.Fatal("tunnel connected over HTTP/2 with the quic protocol")
+	}
+	if got := tunnel.GetProtocol(); got != "" {
+		t.Errorf("protocol = %q after a failed QUIC-only run, want none", got)
+	}
+}
@@ -0,0 +1,172 @@
+package mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// DefaultQuickTunnelService is the service used to request trycloudflare.com tunnels
+const DefaultQuickTunnelService = "https://api.trycloudflare.com"
+
+const quickTunnelRequestTimeout = 15 * time.Second
+
+var (
+	// quickTunnelService overrides DefaultQuickTunnelService for new quick tunnels
+	quickTunnelService   = DefaultQuickTunnelService
+	quickTunnelServiceMu sync.Mutex
+)
+
+// QuickTunnelCallback receives tunnel events plus the public URL assigned to a quick tunnel
+type QuickTunnelCallback interface {
+	TunnelCallback
+	OnQuickTunnelURL(url string)
+}
+
+// quickTunnelResponse is the response of the quick tunnel service
+type quickTunnelResponse struct {
+	Success bool               `json:"success"`
+	Result  quickTunnel        `json:"result"`
+	Errors  []quickTunnelError `json:"errors"`
+}
+
+type quickTunnelError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type quickTunnel struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Hostname   string `json:"hostname"`
+	AccountTag string `json:"account_tag"`
+	Secret     []byte `json:"secret"`
+}
+
+// NewQuickTunnel creates a Tunnel that requests an ephemeral trycloudflare.com
+// tunnel on Start instead of using a token.
+func NewQuickTunnel(originURL string, callback QuickTunnelCallback) (*Tunnel, error) {
+	if originURL == "" {
+		return nil, newTunnelError(ErrCodeInvalidConfig, errors.New("origin URL is required"))
+	}
+
+	quickTunnelServiceMu.Lock()
+	service := quickTunnelService
+	quickTunnelServiceMu.Unlock()
+
+	config := &TunnelConfig{
+		OriginURL:          originURL,
+		HAConnections:      4,
+		QuickTunnel:        true,
+		QuickTunnelService: service,
+		Retries:            unsetCount,
+		MaxEdgeAddrRetries: unsetCount,
+	}
+
+	tunnel := newTunnel(config, callback)
+	tunnel.logCallback(0, "[NewQuickTunnel] Creating quick tunnel instance")
+	tunnel.logCallback(0, "[NewQuickTunnel] OriginURL: %s", originURL)
+
+	return tunnel, nil
+}
+
+// requestQuickTunnel asks the quick tunnel service for ephemeral credentials
+// and reports the assigned public URL to the callback.
+func (t *Tunnel) requestQuickTunnel() (*connection.TunnelProperties, error) {
+	t.mu.RLock()
+	service := t.config.QuickTunnelService
+	t.mu.RUnlock()
+	if service == "" {
+		service = DefaultQuickTunnelService
+	}
+	t.notifyState(StateConnecting, "Requesting quick tunnel...")
+
+	req, err := http.NewRequestWithContext(t.ctx, http.MethodPost, strings.TrimSuffix(service, "/")+"/tunnel", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create quick tunnel request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("User-Agent", UserAgent+"/"+Version)
+
+	resolver := t.resolver()
+	defer resolver.Close()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = resolver.DialContext
+	defer transport.CloseIdleConnections()
+	client := http.Client{Timeout: quickTunnelRequestTimeout, Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request quick tunnel: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var data quickTunnelResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal quick tunnel response (status %d): %w", resp.StatusCode, err)
+	}
+	if !data.Success {
+		if len(data.Errors) > 0 {
+			return nil, fmt.Errorf("quick tunnel request failed: %s (code %d)", data.Errors[0].Message, data.Errors[0].Code)
+		}
+		return nil, fmt.Errorf("quick tunnel request failed with status %d", resp.StatusCode)
+	}
+
+	tunnelID, err := uuid.Parse(data.Result.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse quick tunnel ID: %w", err)
+	}
+
+	url := data.Result.Hostname
+	if !strings.HasPrefix(url, "https://") {
+		url = "https://" + url
+	}
+	t.logCallback(0, "[requestQuickTunnel] Quick tunnel created, TunnelID: %s, URL: %s", tunnelID, url)
+	t.mu.Lock()
+	t.quickTunnelHostname = data.Result.Hostname
+	t.mu.Unlock()
+
+	if callback, ok := t.callback.(QuickTunnelCallback); ok {
+		callback.OnQuickTunnelURL(url)
+	}
+
+	return &connection.TunnelProperties{
+		Credentials: connection.Credentials{
+			AccountTag:   data.Result.AccountTag,
+			TunnelSecret: data.Result.Secret,
+			TunnelID:     tunnelID,
+		},
+		QuickTunnelUrl: data.Result.Hostname,
+	}, nil
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// StartQuickTunnel starts an ephemeral trycloudflare.com tunnel to originURL.
+// The assigned public URL is reported via OnQuickTunnelURL.
+// This blocks until the tunnel is stopped or encounters an error.
+func StartQuickTunnel(originURL string, callback QuickTunnelCallback) error {
+	return startGlobalTunnel(func() (*Tunnel, error) {
+		return NewQuickTunnel(originURL, callback)
+	}, callback)
+}
+
+// SetQuickTunnelService sets the base URL of the quick tunnel service used by
+// tunnels created afterwards. An empty string restores the default.
+func SetQuickTunnelService(serviceURL string) {
+	quickTunnelServiceMu.Lock()
+	defer quickTunnelServiceMu.Unlock()
+	if serviceURL == "" {
+		serviceURL = DefaultQuickTunnelService
+	}
+	quickTunnelService = serviceURL
+}
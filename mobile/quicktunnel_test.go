@@ -0,0 +1,131 @@
+package mobile
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// quickTunnelRecorder is a QuickTunnelCallback that also records the assigned URLs
+type quickTunnelRecorder struct {
+	callbackRecorder
+	urlsMu sync.Mutex
+	urls   []string
+}
+
+func (r *quickTunnelRecorder) OnQuickTunnelURL(url string) {
+	r.urlsMu.Lock()
+	defer r.urlsMu.Unlock()
+	r.urls = append(r.urls, url)
+}
+
+func (r *quickTunnelRecorder) getURLs() []string {
+	r.urlsMu.Lock()
+	defer r.urlsMu.Unlock()
+	return append([]string(nil), r.urls...)
+}
+
+// newQuickTunnelService starts a stand-in quick tunnel service answering
+// POST /tunnel with status and body
+func newQuickTunnelService(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/tunnel" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newFakeEdgeQuickTunnel returns a quick tunnel requesting its credentials from
+// service and connecting to edge
+func newFakeEdgeQuickTunnel(t *testing.T, edge *fakeEdge, service string, callback QuickTunnelCallback) *Tunnel {
+	t.Helper()
+	SetQuickTunnelService(service)
+	t.Cleanup(func() { SetQuickTunnelService("") })
+
+	tunnel, err := NewQuickTunnel("http://localhost:8080", callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tunnel.config.QuickTunnelService != service {
+		t.Fatalf("quick tunnel service = %q, want %q", tunnel.config.QuickTunnelService, service)
+	}
+	tunnel.config.HAConnections = 2
+	tunnel.config.EdgeAddrs = []string{edge.addr()}
+	tunnel.config.Resolver = &ResolverConfig{
+		Mode:      ResolverModeCustom,
+		Servers:   []string{"127.0.0.1:1"},
+		Transport: ResolverTransportUDP,
+		TimeoutMs: 500,
+	}
+	tunnel.daemon = edge.daemon()
+	return tunnel
+}
+
+func TestQuickTunnelReportsURL(t *testing.T) {
+	tunnelID := uuid.New()
+	service := newQuickTunnelService(t, http.StatusOK, `{"success":true,"result":{"id":"`+tunnelID.String()+
+		`","name":"qt","hostname":"quick-test.trycloudflare.com","account_tag":"quick-account","secret":"c2VjcmV0"}}`)
+	edge := newFakeEdge(t)
+	recorder := &quickTunnelRecorder{}
+	tunnel := newFakeEdgeQuickTunnel(t, edge, service.URL, recorder)
+
+	startConnected(t, tunnel)
+	if urls := recorder.getURLs(); len(urls) != 1 || urls[0] != "https://quick-test.trycloudflare.com" {
+		t.Errorf("OnQuickTunnelURL got %v, want the assigned hostname once", urls)
+	}
+	tunnel.mu.RLock()
+	gotID, hostname := tunnel.tunnelID, tunnel.quickTunnelHostname
+	tunnel.mu.RUnlock()
+	if gotID != tunnelID.String() || hostname != "quick-test.trycloudflare.com" {
+		t.Errorf("tunnel ID = %s, hostname = %q; want %s and the assigned hostname", gotID, hostname, tunnelID)
+	}
+}
+
+func TestQuickTunnelServiceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		message string
+	}{
+		{"service error", http.StatusTooManyRequests, `{"success":false,"errors":[{"code":1015,"message":"rate limited"}]}`, "rate limited (code 1015)"},
+		{"non-2xx without errors", http.StatusInternalServerError, `{"success":false}`, "status 500"},
+		{"malformed JSON", http.StatusOK, `<html>oops</html>`, "failed to unmarshal quick tunnel response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newQuickTunnelService(t, tt.status, tt.body)
+			edge := newFakeEdge(t)
+			recorder := &quickTunnelRecorder{}
+			tunnel := newFakeEdgeQuickTunnel(t, edge, service.URL, recorder)
+
+			err := tunnel.Start()
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("Start = %v, want an error containing %q", err, tt.message)
+			}
+			if urls := recorder.getURLs(); len(urls) != 0 {
+				t.Errorf("OnQuickTunnelURL got %v after a failed request", urls)
+			}
+			if errs := recorder.getErrors(); len(errs) != 1 || !strings.Contains(errs[0].Message, tt.message) {
+				t.Errorf("OnError got %v, want the request error", errs)
+			}
+			if state := TunnelState(tunnel.GetState()); state != StateError {
+				t.Errorf("state = %s, want error", state)
+			}
+			if orchestrators := edge.getOrchestrators(); len(orchestrators) != 0 {
+				t.Error("daemon started without quick tunnel credentials")
+			}
+		})
+	}
+}
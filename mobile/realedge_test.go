@@ -0,0 +1,327 @@
+//go:build integration
+
+package mobile
+
+// These tests run cloudflared's own supervisor and HTTP/2 transport against a
+// loopback edge instead of the fakeEdge daemon, so they need the cloudflared
+// checkout the module builds against: go test -tags integration ./...
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/http2"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/tunnelrpc"
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+// realEdge is a loopback edge speaking cloudflared's HTTP/2 transport: it
+// accepts the TLS connection, opens the control stream and serves the
+// registration RPCs on it
+type realEdge struct {
+	listener   net.Listener
+	serverName string
+	tlsConfig  *tls.Config
+	rootCAs    *x509.CertPool
+	ctx        context.Context
+
+	registrations   atomic.Int32
+	unregistrations atomic.Int32
+	open            atomic.Int64
+	wg              sync.WaitGroup
+
+	sourcesMu sync.Mutex
+	sources   map[string]int
+}
+
+// newRealEdge starts an edge with a certificate for cloudflared's HTTP/2 server
+// name, issued by a fresh test CA
+func newRealEdge(tb testing.TB) *realEdge {
+	tb.Helper()
+	serverName := connection.HTTP2.TLSSettings().ServerName
+	rootCAs, certificate := newTestEdgeCertificate(tb, serverName)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &realEdge{
+		listener:   listener,
+		serverName: serverName,
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			NextProtos:   []string{http2.NextProtoTLS},
+		},
+		rootCAs: rootCAs,
+		ctx:     ctx,
+		sources: make(map[string]int),
+	}
+	e.wg.Add(1)
+	go e.serve()
+	tb.Cleanup(func() {
+		cancel()
+		listener.Close()
+		e.wg.Wait()
+	})
+	return e
+}
+
+// newTestEdgeCertificate returns a CA pool and a certificate for serverName signed by it
+func newTestEdgeCertificate(tb testing.TB, serverName string) (*x509.CertPool, tls.Certificate) {
+	tb.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test edge CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (e *realEdge) serve() {
+	defer e.wg.Done()
+	for {
+		conn, err := e.listener.Accept()
+		if err != nil {
+			return
+		}
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			e.sourcesMu.Lock()
+			e.sources[addr.IP.String()]++
+			e.sourcesMu.Unlock()
+		}
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.handle(conn)
+		}()
+	}
+}
+
+// handle opens the control stream on one edge connection and serves
+// registration until the connection closes
+func (e *realEdge) handle(conn net.Conn) {
+	e.open.Add(1)
+	defer e.open.Add(-1)
+	defer conn.Close()
+
+	tlsConn := tls.Server(conn, e.tlsConfig)
+	if err := tlsConn.HandshakeContext(e.ctx); err != nil {
+		return
+	}
+	// cloudflared is the HTTP/2 server on the edge connection; the edge sends requests
+	client, err := (&http2.Transport{}).NewClientConn(tlsConn)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+
+	body, requestWriter := io.Pipe()
+	defer requestWriter.Close()
+	request, err := http.NewRequestWithContext(e.ctx, http.MethodGet, "https://"+e.serverName, body)
+	if err != nil {
+		return
+	}
+	request.Header.Set("Cf-Cloudflared-Proxy-Connection-Upgrade", "control-stream")
+	response, err := client.RoundTrip(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+
+	stream := &controlStream{Reader: response.Body, WriteCloser: requestWriter}
+	_ = tunnelrpc.NewRegistrationServer(e).Serve(e.ctx, stream)
+}
+
+// controlStream joins the two halves of the control stream request
+type controlStream struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (e *realEdge) RegisterConnection(ctx context.Context, auth pogs.TunnelAuth, tunnelID uuid.UUID, connIndex byte, options *pogs.ConnectionOptions) (*pogs.ConnectionDetails, error) {
+	e.registrations.Add(1)
+	return &pogs.ConnectionDetails{UUID: uuid.New(), Location: "LOOPBACK"}, nil
+}
+
+func (e *realEdge) UnregisterConnection(ctx context.Context) {
+	e.unregistrations.Add(1)
+}
+
+func (e *realEdge) UpdateLocalConfiguration(ctx context.Context, config []byte) error {
+	return nil
+}
+
+// connectionsFrom returns the number of connections accepted from source
+func (e *realEdge) connectionsFrom(source string) int {
+	e.sourcesMu.Lock()
+	defer e.sourcesMu.Unlock()
+	return e.sources[source]
+}
+
+func (e *realEdge) addr() string {
+	return e.listener.Addr().String()
+}
+
+// newRealEdgeTunnel returns a tunnel that runs cloudflared's supervisor against edge
+func newRealEdgeTunnel(tb testing.TB, edge *realEdge, config *TunnelConfig) *Tunnel {
+	tb.Helper()
+	config.Token = testToken(tb)
+	config.Protocol = ProtocolHTTP2
+	config.HAConnections = 2
+	config.EdgeAddrs = []string{edge.addr()}
+	config.Retries = unsetCount
+	config.MaxEdgeAddrRetries = unsetCount
+	config.Resolver = &ResolverConfig{
+		Mode:      ResolverModeCustom,
+		Servers:   []string{"127.0.0.1:1"},
+		Transport: ResolverTransportUDP,
+		TimeoutMs: 500,
+	}
+	tunnel := newTunnel(config, nil)
+	tunnel.edgeRootCAs = edge.rootCAs
+	return tunnel
+}
+
+func TestRealSupervisorConnectsAndStops(t *testing.T) {
+	edge := newRealEdge(t)
+	tunnel := newRealEdgeTunnel(t, edge, &TunnelConfig{})
+
+	startConnected(t, tunnel)
+	if got := edge.registrations.Load(); got != 2 {
+		t.Errorf("edge registered %d connections, want 2", got)
+	}
+	if got := tunnel.GetProtocol(); got != ProtocolHTTP2 {
+		t.Errorf("protocol = %q, want %s", got, ProtocolHTTP2)
+	}
+	if snapshot := metricsSnapshot(t, tunnel); snapshot.HAConnections != 2 {
+		t.Errorf("haConnections = %d, want 2", snapshot.HAConnections)
+	}
+
+	stoppedGracefully, err := tunnel.StopWithTimeout(5000)
+	if err != nil || !stoppedGracefully {
+		t.Fatalf("StopWithTimeout = %v, %v; want a graceful stop", stoppedGracefully, err)
+	}
+	if got := edge.unregistrations.Load(); got != 2 {
+		t.Errorf("edge saw %d unregistrations, want 2", got)
+	}
+	if !waitFor(5*time.Second, func() bool { return edge.open.Load() == 0 }) {
+		t.Errorf("edge still has %d open connections", edge.open.Load())
+	}
+}
+
+func TestRealSupervisorDialsFromBindAddress(t *testing.T) {
+	for _, source := range []string{"127.0.0.2", "127.0.0.3"} {
+		if listener, err := net.Listen("tcp", source+":0"); err != nil {
+			t.Skipf("%s is not a local address: %v", source, err)
+		} else {
+			listener.Close()
+		}
+	}
+	edge := newRealEdge(t)
+	tunnel := newRealEdgeTunnel(t, edge, &TunnelConfig{BindAddress: "127.0.0.2"})
+
+	startConnected(t, tunnel)
+	if got := edge.connectionsFrom("127.0.0.2"); got != 2 {
+		t.Errorf("edge accepted %d connections from 127.0.0.2, want 2", got)
+	}
+
+	// Rebind restarts the supervisor, whose dialer then binds to the new address
+	if err := tunnel.Rebind("127.0.0.3"); err != nil {
+		t.Fatalf("Rebind failed: %v", err)
+	}
+	if !waitFor(10*time.Second, func() bool { return edge.connectionsFrom("127.0.0.3") == 2 && tunnel.readyConnections() == 2 }) {
+		t.Fatalf("edge accepted %d connections from 127.0.0.3 after a rebind, want 2", edge.connectionsFrom("127.0.0.3"))
+	}
+	if got := edge.connectionsFrom("127.0.0.2"); got != 2 {
+		t.Errorf("edge accepted %d connections from the old address, want the original 2", got)
+	}
+}
+
+func TestRealSupervisorStartStopCyclesDoNotLeak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping start/stop cycles in short mode")
+	}
+	const cycles = 50
+
+	edge := newRealEdge(t)
+	tunnel := newRealEdgeTunnel(t, edge, &TunnelConfig{MetricsAddress: "127.0.0.1:0"})
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	runCycle(t, tunnel, client, true)
+	if !waitFor(5*time.Second, func() bool { return edge.open.Load() == 0 }) {
+		t.Fatalf("edge still has %d open connections after warm-up", edge.open.Load())
+	}
+	baseGoroutines := runtime.NumGoroutine()
+	baseSockets := openSockets()
+
+	for i := 0; i < cycles; i++ {
+		runCycle(t, tunnel, client, i%2 == 0)
+	}
+
+	// cloudflared dials the edge itself, so its sockets only show up process-wide
+	if !waitFor(5*time.Second, func() bool { return edge.open.Load() == 0 }) {
+		t.Errorf("edge still has %d open connections", edge.open.Load())
+	}
+	if !waitFor(5*time.Second, func() bool { return runtime.NumGoroutine() <= baseGoroutines }) {
+		t.Errorf("goroutines grew from %d to %d over %d cycles", baseGoroutines, runtime.NumGoroutine(), cycles)
+	}
+	if baseSockets >= 0 {
+		if !waitFor(5*time.Second, func() bool { return openSockets() <= baseSockets }) {
+			t.Errorf("open sockets grew from %d to %d over %d cycles", baseSockets, openSockets(), cycles)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package mobile
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+// reconnectTimeout is how long a forced reconnect may take before it is reported as failed
+const reconnectTimeout = 30 * time.Second
+
+// pendingReconnect tracks a forced reconnect until the connections are back
+type pendingReconnect struct {
+	reason      string
+	started     time.Time
+	expected    int
+	reconnected map[uint8]bool
+	timer       *time.Timer
+}
+
+// requestReconnect asks the registered HA connections to drop and re-establish
+// their edge connection. Each signal on reconnectCh is consumed by one serving
+// connection, so only as many signals as there are registered connections are
+// sent, after draining any left unconsumed. It returns the number of signals queued.
+func (t *Tunnel) requestReconnect() int {
+	t.mu.RLock()
+	reconnectCh := t.reconnectCh
+	t.mu.RUnlock()
+
+	if reconnectCh == nil {
+		return 0
+	}
+
+	for drained := false; !drained; {
+		select {
+		case <-reconnectCh:
+		default:
+			drained = true
+		}
+	}
+
+	sent := 0
+	for i := uint(0); i < t.readyConnections(); i++ {
+		select {
+		case reconnectCh <- supervisor.ReconnectSignal{Delay: 0}:
+			sent++
+		default:
+			// Channel full: every connection already has a pending signal
+		}
+	}
+	return sent
+}
+
+// reconnect forces the HA connections to reconnect and reports the outcome via
+// the callbacks once they are registered again, or after reconnectTimeout
+func (t *Tunnel) reconnect(reason string) (int, error) {
+	sent := t.requestReconnect()
+	if sent == 0 {
+		return 0, errors.New("no edge connection to reconnect")
+	}
+	t.trackReconnect(reason, sent)
+	return sent, nil
+}
+
+// trackReconnect reports via the callbacks when expected connections are
+// registered again, or that they were not after reconnectTimeout
+func (t *Tunnel) trackReconnect(reason string, expected int) {
+	pending := &pendingReconnect{
+		reason:      reason,
+		started:     time.Now(),
+		expected:    expected,
+		reconnected: make(map[uint8]bool),
+	}
+	pending.timer = time.AfterFunc(reconnectTimeout, func() { t.reconnectTimedOut(pending) })
+
+	t.mu.Lock()
+	if previous := t.pendingReconnect; previous != nil {
+		previous.timer.Stop()
+	}
+	t.pendingReconnect = pending
+	state := t.state
+	t.mu.Unlock()
+
+	t.logCallback(1, "[reconnect] %s: reconnecting %d edge connections", reason, expected)
+	t.notifyState(state, fmt.Sprintf("%s, reconnecting %d connections...", reason, expected))
+}
+
+// observeReconnect counts connections registered during a forced reconnect
+func (t *Tunnel) observeReconnect(index uint8) {
+	t.mu.Lock()
+	pending := t.pendingReconnect
+	if pending == nil {
+		t.mu.Unlock()
+		return
+	}
+	pending.reconnected[index] = true
+	if len(pending.reconnected) < pending.expected {
+		t.mu.Unlock()
+		return
+	}
+	pending.timer.Stop()
+	t.pendingReconnect = nil
+	state := t.state
+	t.mu.Unlock()
+
+	elapsed := time.Since(pending.started).Round(time.Millisecond)
+	t.logCallback(0, "[reconnect] %s: %d connections re-established in %s", pending.reason, pending.expected, elapsed)
+	t.notifyState(state, fmt.Sprintf("Reconnected %d connections in %s", pending.expected, elapsed))
+}
+
+// reconnectTimedOut reports a forced reconnect that did not complete in time
+func (t *Tunnel) reconnectTimedOut(pending *pendingReconnect) {
+	t.mu.Lock()
+	if t.pendingReconnect != pending {
+		t.mu.Unlock()
+		return
+	}
+	t.pendingReconnect = nil
+	reconnected := len(pending.reconnected)
+	state := t.state
+	t.mu.Unlock()
+
+	if reconnected > 0 {
+		t.logCallback(1, "[reconnect] %s: only %d/%d connections re-established after %s", pending.reason, reconnected, pending.expected, reconnectTimeout)
+		t.notifyState(state, fmt.Sprintf("Reconnected %d/%d connections", reconnected, pending.expected))
+		return
+	}
+	msg := fmt.Sprintf("no edge connection re-established within %s after %s", reconnectTimeout, pending.reason)
+	t.logCallback(2, "[reconnect] %s", msg)
+	if t.callback != nil {
+		t.callback.OnError(int(ErrCodeEdgeUnreachable), msg)
+	}
+}
+
+// cancelReconnect drops a pending forced reconnect when the tunnel stops
+func (t *Tunnel) cancelReconnect() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pendingReconnect != nil {
+		t.pendingReconnect.timer.Stop()
+		t.pendingReconnect = nil
+	}
+}
+
+// NotifyNetworkChanged tells the tunnel the device switched networks (e.g. Wi-Fi
+// to cellular). The edge connections are re-established immediately instead of
+// waiting for the old ones to time out. The outcome is reported via OnStateChanged,
+// or OnError if no connection comes back.
+func (t *Tunnel) NotifyNetworkChanged() error {
+	_, err := t.reconnect("Network changed")
+	return err
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// NotifyNetworkChanged forces the global tunnel to reconnect after a network
+// change. Call it from the app's connectivity listener; it does nothing if no
+// tunnel is connected.
+func NotifyNetworkChanged() {
+	tunnelMu.Lock()
+	tunnel := globalTunnel
+	tunnelMu.Unlock()
+
+	if tunnel != nil {
+		if err := tunnel.NotifyNetworkChanged(); err != nil {
+			tunnel.logCallback(0, "[reconnect] Network change ignored: %v", err)
+		}
+	}
+}
+
+// NotifyNetworkChangedByID forces a tunnel created with CreateTunnel to reconnect after a network change
+func NotifyNetworkChangedByID(id string) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	return tunnel.NotifyNetworkChanged()
+}
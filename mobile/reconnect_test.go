@@ -0,0 +1,86 @@
+package mobile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+// newReconnectTunnel returns a tunnel with connected HA connections and a
+// reconnect channel, without a running daemon
+func newReconnectTunnel(t *testing.T, connected int, callback TunnelCallback) *Tunnel {
+	t.Helper()
+	tunnel := newTunnel(&TunnelConfig{Token: testToken(t), HAConnections: 4}, callback)
+	tunnel.mu.Lock()
+	defer tunnel.mu.Unlock()
+	tunnel.reconnectCh = make(chan supervisor.ReconnectSignal, 4)
+	tunnel.connections = make(map[uint8]*ConnectionInfo)
+	for i := 0; i < connected; i++ {
+		tunnel.connections[uint8(i)] = &ConnectionInfo{Index: i, State: ConnEventConnected}
+	}
+	return tunnel
+}
+
+func TestRequestReconnectSignalsEachConnection(t *testing.T) {
+	tunnel := newReconnectTunnel(t, 2, nil)
+	// A signal left over from an earlier request is dropped, not added to
+	tunnel.reconnectCh <- supervisor.ReconnectSignal{}
+
+	if sent := tunnel.requestReconnect(); sent != 2 {
+		t.Errorf("requestReconnect sent %d signals, want 2", sent)
+	}
+	if queued := len(tunnel.reconnectCh); queued != 2 {
+		t.Errorf("%d signals queued, want one per connected connection", queued)
+	}
+
+	// Without a running daemon there is nothing to reconnect
+	idle := newTunnel(&TunnelConfig{Token: testToken(t)}, nil)
+	if err := idle.NotifyNetworkChanged(); err == nil {
+		t.Error("NotifyNetworkChanged succeeded on a stopped tunnel")
+	}
+	if err := newReconnectTunnel(t, 0, nil).NotifyNetworkChanged(); err == nil {
+		t.Error("NotifyNetworkChanged succeeded without a connected connection")
+	}
+}
+
+func TestReconnectOutcomeIsReported(t *testing.T) {
+	recorder := &callbackRecorder{}
+	tunnel := newReconnectTunnel(t, 2, recorder)
+
+	// Both connections come back
+	if err := tunnel.NotifyNetworkChanged(); err != nil {
+		t.Fatal(err)
+	}
+	tunnel.observeReconnect(0)
+	tunnel.observeReconnect(1)
+	if states := recorder.getStates(); len(states) == 0 || !strings.HasPrefix(states[len(states)-1].Message, "Reconnected 2 connections in ") {
+		t.Errorf("state notifications = %v, want the completed reconnect", states)
+	}
+
+	// Only one comes back before the timeout
+	tunnel.trackReconnect("Network changed", 2)
+	tunnel.observeReconnect(1)
+	tunnel.mu.RLock()
+	pending := tunnel.pendingReconnect
+	tunnel.mu.RUnlock()
+	tunnel.reconnectTimedOut(pending)
+	if states := recorder.getStates(); states[len(states)-1].Message != "Reconnected 1/2 connections" {
+		t.Errorf("last state notification = %+v, want a partial reconnect", states[len(states)-1])
+	}
+
+	// None comes back
+	tunnel.trackReconnect("Network changed", 2)
+	tunnel.mu.RLock()
+	pending = tunnel.pendingReconnect
+	tunnel.mu.RUnlock()
+	tunnel.reconnectTimedOut(pending)
+	if errs := recorder.getErrors(); len(errs) != 1 || errs[0].Code != ErrCodeEdgeUnreachable {
+		t.Errorf("callback errors = %v, want one edge unreachable", errs)
+	}
+	tunnel.mu.RLock()
+	defer tunnel.mu.RUnlock()
+	if tunnel.pendingReconnect != nil {
+		t.Error("timed out reconnect still pending")
+	}
+}
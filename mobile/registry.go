@@ -0,0 +1,237 @@
+package mobile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// tunnels holds the tunnels created with CreateTunnel, keyed by ID
+	tunnels    = make(map[string]*Tunnel)
+	registryMu sync.Mutex
+)
+
+// TunnelSummary describes a registered tunnel in ListTunnels
+type TunnelSummary struct {
+	ID           string `json:"id"`
+	State        int    `json:"state"`
+	StateString  string `json:"stateString"`
+	OriginURL    string `json:"originUrl"`
+	QuickTunnel  bool   `json:"quickTunnel"`
+	ConfigSource string `json:"configSource"`
+}
+
+// parseTunnelConfig decodes and validates a JSON tunnel configuration
+func parseTunnelConfig(configJSON string) (*TunnelConfig, error) {
+	// Omitted counts stay unset so an explicit 0 can be told apart
+	config := TunnelConfig{Retries: unsetCount, MaxEdgeAddrRetries: unsetCount}
+	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("failed to parse tunnel config: %w", err))
+	}
+
+	if config.QuickTunnel {
+		if config.OriginURL == "" {
+			return nil, newTunnelError(ErrCodeInvalidConfig, errors.New("origin URL is required"))
+		}
+		if config.QuickTunnelService == "" {
+			quickTunnelServiceMu.Lock()
+			config.QuickTunnelService = quickTunnelService
+			quickTunnelServiceMu.Unlock()
+		}
+	} else if config.Token == "" {
+		return nil, newTunnelError(ErrCodeInvalidToken, errors.New("token is required"))
+	}
+
+	if _, err := parseLogLevel(config.LogLevel); err != nil {
+		return nil, err
+	}
+
+	protocol, err := parseProtocol(config.Protocol, config.EnablePostQuantum)
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = protocol
+
+	if config.Resolver != nil {
+		if err := normalizeResolverConfig(config.Resolver); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := parseEdgeIPVersion(config.EdgeIPVersion); err != nil {
+		return nil, err
+	}
+	if err := validateRegion(config.Region); err != nil {
+		return nil, err
+	}
+	if err := validateEdgeAddrs(config.EdgeAddrs); err != nil {
+		return nil, err
+	}
+
+	if config.MetricsAddress != "" {
+		if err := validateMetricsAddress(config.MetricsAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, rule := range config.IngressRules {
+		if rule.Service == "" {
+			return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("ingress rule #%d has no service", i+1))
+		}
+	}
+
+	if err := validateTags(config.Tags); err != nil {
+		return nil, err
+	}
+	if config.ConnectorID != "" {
+		if _, err := uuid.Parse(config.ConnectorID); err != nil {
+			return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid connector ID %q: %w", config.ConnectorID, err))
+		}
+	}
+
+	if config.MaxEdgeAddrRetries > 255 {
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("maxEdgeAddrRetries must be at most 255, got %d", config.MaxEdgeAddrRetries))
+	}
+	if config.Retries < unsetCount || config.MaxEdgeAddrRetries < unsetCount {
+		return nil, newTunnelError(ErrCodeInvalidConfig, errors.New("retries must be -1 (default) or more"))
+	}
+	if config.GracePeriodMs < 0 || config.RPCTimeoutMs < 0 || config.ConnectTimeoutMs < 0 {
+		return nil, newTunnelError(ErrCodeInvalidConfig, errors.New("timeouts must not be negative"))
+	}
+
+	if config.HAConnections < 1 {
+		config.HAConnections = 4
+	}
+
+	return &config, nil
+}
+
+// lookupTunnel returns the registered tunnel with the given ID
+func lookupTunnel(id string) (*Tunnel, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	tunnel, ok := tunnels[id]
+	if !ok {
+		return nil, fmt.Errorf("tunnel %s not found", id)
+	}
+	return tunnel, nil
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// StartTunnelWithConfig starts the global tunnel from a JSON config (see CreateTunnel).
+// This blocks until the tunnel is stopped or encounters an error.
+func StartTunnelWithConfig(configJSON string, callback TunnelCallback) error {
+	config, err := parseTunnelConfig(configJSON)
+	if err != nil {
+		return err
+	}
+	return startGlobalTunnel(func() (*Tunnel, error) {
+		return newTunnel(config, callback), nil
+	}, callback)
+}
+
+// CreateTunnel registers a new tunnel from a JSON config and returns its ID.
+// The config accepts the TunnelConfig fields, e.g.
+// {"token": "...", "originUrl": "http://127.0.0.1:8080", "haConnections": 4}.
+// Tunnels created this way run independently of the global tunnel.
+func CreateTunnel(configJSON string, callback TunnelCallback) (string, error) {
+	config, err := parseTunnelConfig(configJSON)
+	if err != nil {
+		return "", err
+	}
+
+	tunnel := newTunnel(config, callback)
+	tunnel.id = uuid.New().String()
+
+	registryMu.Lock()
+	tunnels[tunnel.id] = tunnel
+	registryMu.Unlock()
+
+	tunnel.logCallback(0, "[CreateTunnel] Created tunnel %s", tunnel.id)
+	return tunnel.id, nil
+}
+
+// StartTunnelByID starts a tunnel created with CreateTunnel.
+// This blocks until the tunnel is stopped or encounters an error.
+func StartTunnelByID(id string) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	return tunnel.Start()
+}
+
+// StopTunnelByID stops a tunnel created with CreateTunnel.
+// The tunnel stays registered and can be started again.
+func StopTunnelByID(id string) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	tunnel.Stop()
+	return nil
+}
+
+// RemoveTunnel stops a tunnel created with CreateTunnel and removes it from the registry
+func RemoveTunnel(id string) error {
+	registryMu.Lock()
+	tunnel, ok := tunnels[id]
+	delete(tunnels, id)
+	registryMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("tunnel %s not found", id)
+	}
+	tunnel.Stop()
+	return nil
+}
+
+// GetTunnelStateByID returns the state of a tunnel created with CreateTunnel
+func GetTunnelStateByID(id string) (int, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return int(StateDisconnected), err
+	}
+	return tunnel.GetState(), nil
+}
+
+// ListTunnels returns the tunnels created with CreateTunnel as a JSON array
+func ListTunnels() string {
+	registryMu.Lock()
+	list := make([]*Tunnel, 0, len(tunnels))
+	for _, tunnel := range tunnels {
+		list = append(list, tunnel)
+	}
+	registryMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+
+	summaries := make([]TunnelSummary, 0, len(list))
+	for _, tunnel := range list {
+		tunnel.mu.RLock()
+		summaries = append(summaries, TunnelSummary{
+			ID:           tunnel.id,
+			State:        int(tunnel.state),
+			StateString:  tunnel.state.String(),
+			OriginURL:    tunnel.config.OriginURL,
+			QuickTunnel:  tunnel.config.QuickTunnel,
+			ConfigSource: tunnel.configSource,
+		})
+		tunnel.mu.RUnlock()
+	}
+
+	data, err := json.Marshal(summaries)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
@@ -0,0 +1,146 @@
+package mobile
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// createFakeEdgeTunnel registers a tunnel with CreateTunnel that connects to edge
+func createFakeEdgeTunnel(t *testing.T, edge *fakeEdge) string {
+	t.Helper()
+	configJSON, err := json.Marshal(map[string]interface{}{
+		"token":         testToken(t),
+		"originUrl":     "http://127.0.0.1:8080",
+		"haConnections": 2,
+		"edgeAddrs":     []string{edge.addr()},
+		"resolver": map[string]interface{}{
+			"mode":      ResolverModeCustom,
+			"servers":   []string{"127.0.0.1:1"},
+			"transport": ResolverTransportUDP,
+			"timeoutMs": 500,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := CreateTunnel(string(configJSON), nil)
+	if err != nil {
+		t.Fatalf("CreateTunnel: %v", err)
+	}
+	t.Cleanup(func() { _ = RemoveTunnel(id) })
+
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel.daemon = edge.daemon()
+	return id
+}
+
+// startTunnelByID runs StartTunnelByID in the background and returns its result channel
+func startTunnelByID(id string) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- StartTunnelByID(id) }()
+	return result
+}
+
+// metricsByID returns the decoded GetTunnelMetricsByID of a tunnel
+func metricsByID(t *testing.T, id string) MetricsSnapshot {
+	t.Helper()
+	data, err := GetTunnelMetricsByID(id)
+	if err != nil {
+		t.Fatalf("GetTunnelMetricsByID: %v", err)
+	}
+	var snapshot MetricsSnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		t.Fatalf("invalid metrics snapshot: %v", err)
+	}
+	return snapshot
+}
+
+// listedStates returns the states reported by ListTunnels, keyed by tunnel ID
+func listedStates(t *testing.T) map[string]string {
+	t.Helper()
+	var summaries []TunnelSummary
+	if err := json.Unmarshal([]byte(ListTunnels()), &summaries); err != nil {
+		t.Fatalf("invalid tunnel list: %v", err)
+	}
+	states := make(map[string]string, len(summaries))
+	for _, summary := range summaries {
+		states[summary.ID] = summary.StateString
+	}
+	return states
+}
+
+func TestConcurrentTunnelsByID(t *testing.T) {
+	edge := newFakeEdge(t)
+	first := createFakeEdgeTunnel(t, edge)
+	second := createFakeEdgeTunnel(t, edge)
+
+	firstDone := startTunnelByID(first)
+	secondDone := startTunnelByID(second)
+	connected := func() bool {
+		for _, id := range []string{first, second} {
+			if metricsByID(t, id).HAConnections != 2 {
+				return false
+			}
+		}
+		return true
+	}
+	if !waitFor(5*time.Second, connected) {
+		t.Fatalf("tunnels did not both connect: %v", listedStates(t))
+	}
+
+	states := listedStates(t)
+	if states[first] != StateConnected.String() || states[second] != StateConnected.String() {
+		t.Errorf("ListTunnels states = %v, want both connected", states)
+	}
+	for _, id := range []string{first, second} {
+		if snapshot := metricsByID(t, id); snapshot.Registrations != 2 || snapshot.RunningTunnels != 2 {
+			t.Errorf("tunnel %s: registrations = %d, runningTunnels = %d; want 2 and 2", id, snapshot.Registrations, snapshot.RunningTunnels)
+		}
+	}
+
+	// Stopping one tunnel leaves the other running with its metrics
+	if err := StopTunnelByID(first); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-firstDone:
+		if err != nil {
+			t.Errorf("StartTunnelByID returned %v after a stop", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartTunnelByID did not return after StopTunnelByID")
+	}
+	if state, _ := GetTunnelStateByID(first); state != int(StateDisconnected) {
+		t.Errorf("stopped tunnel state = %s, want disconnected", TunnelState(state))
+	}
+	if snapshot := metricsByID(t, second); snapshot.HAConnections != 2 || snapshot.RunningTunnels != 1 {
+		t.Errorf("remaining tunnel: haConnections = %d, runningTunnels = %d; want 2 and 1", snapshot.HAConnections, snapshot.RunningTunnels)
+	}
+
+	// A stopped tunnel starts again and keeps counting
+	firstDone = startTunnelByID(first)
+	if !waitFor(5*time.Second, connected) {
+		t.Fatalf("tunnel did not reconnect after a restart: %v", listedStates(t))
+	}
+	if snapshot := metricsByID(t, first); snapshot.Registrations != 4 {
+		t.Errorf("restarted tunnel: registrations = %d, want 4", snapshot.Registrations)
+	}
+
+	for id, done := range map[string]<-chan error{first: firstDone, second: secondDone} {
+		if err := RemoveTunnel(id); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("tunnel %s did not stop after RemoveTunnel", id)
+		}
+	}
+	if _, err := GetTunnelMetricsByID(first); err == nil {
+		t.Error("GetTunnelMetricsByID succeeded for a removed tunnel")
+	}
+}
@@ -0,0 +1,403 @@
+package mobile
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver modes for ResolverConfig.Mode
+const (
+	// ResolverModeSystem uses the platform resolver only
+	ResolverModeSystem = "system"
+	// ResolverModeCustom queries ResolverConfig.Servers in order
+	ResolverModeCustom = "custom"
+	// ResolverModeDoH sends queries to ResolverConfig.DoHURL over HTTPS (RFC 8484)
+	ResolverModeDoH = "doh"
+)
+
+// Resolver transports for ResolverConfig.Transport
+const (
+	ResolverTransportUDP = "udp"
+	ResolverTransportTCP = "tcp"
+)
+
+// defaultResolverTimeout bounds a single lookup against one server
+const defaultResolverTimeout = 5 * time.Second
+
+// defaultResolverServers are used in custom mode when no servers are configured
+var defaultResolverServers = []string{"1.1.1.1:53", "1.0.0.1:53"}
+
+// Edge discovery records published by Cloudflare (see cloudflared's allregions package)
+const (
+	edgeSRVService = "v2-origintunneld"
+	edgeSRVProto   = "tcp"
+	edgeSRVName    = "argotunnel.com"
+)
+
+// ResolverConfig controls how the tunnel resolves the Cloudflare edge, its
+// feature rollout record and the quick tunnel service. It only applies to the
+// tunnel's own lookups; the process-wide net.DefaultResolver is left untouched.
+type ResolverConfig struct {
+	// Mode is "custom" (default), "doh" or "system"
+	Mode string `json:"mode"`
+	// Servers are queried in order, as "ip" or "ip:port" (default: 1.1.1.1, 1.0.0.1)
+	Servers []string `json:"servers,omitempty"`
+	// Transport is "udp" (default) or "tcp"
+	Transport string `json:"transport,omitempty"`
+	// DoHURL is the DNS-over-HTTPS endpoint in doh mode (default: https://cloudflare-dns.com/dns-query)
+	DoHURL string `json:"dohUrl,omitempty"`
+	// BootstrapIPs are dialed to reach the DoH host without resolving it
+	// (default: 1.1.1.1, 1.0.0.1 for the default URL; empty uses the system resolver)
+	BootstrapIPs []string `json:"bootstrapIps,omitempty"`
+	// FallbackToSystem queries the system resolver after all custom servers failed
+	FallbackToSystem bool `json:"fallbackToSystem"`
+	// TimeoutMs bounds each lookup against a single server (default: 5000)
+	TimeoutMs int `json:"timeoutMs,omitempty"`
+
+	// rootCAs overrides the roots trusted for the DoH server (nil: system roots)
+	rootCAs *x509.CertPool
+}
+
+// defaultResolverConfig keeps the previous behaviour of querying 1.1.1.1, and
+// falls back to the system resolver if external DNS is blocked
+func defaultResolverConfig() *ResolverConfig {
+	return &ResolverConfig{
+		Mode:             ResolverModeCustom,
+		Servers:          append([]string(nil), defaultResolverServers...),
+		Transport:        ResolverTransportUDP,
+		FallbackToSystem: true,
+		TimeoutMs:        int(defaultResolverTimeout / time.Millisecond),
+	}
+}
+
+var (
+	// globalResolverConfig is applied to tunnels started by the static API
+	globalResolverConfig *ResolverConfig
+	resolverSettingsMu   sync.Mutex
+)
+
+// parseResolverConfig decodes and validates a JSON resolver configuration
+func parseResolverConfig(configJSON string) (*ResolverConfig, error) {
+	var config ResolverConfig
+	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
+		return nil, newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("failed to parse resolver config: %w", err))
+	}
+	if err := normalizeResolverConfig(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// normalizeResolverConfig validates the config and fills in defaults
+func normalizeResolverConfig(config *ResolverConfig) error {
+	config.Mode = strings.ToLower(strings.TrimSpace(config.Mode))
+	switch config.Mode {
+	case "":
+		config.Mode = ResolverModeCustom
+	case ResolverModeCustom, ResolverModeDoH, ResolverModeSystem:
+	default:
+		return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid resolver mode: %q (expected system, custom or doh)", config.Mode))
+	}
+
+	config.Transport = strings.ToLower(strings.TrimSpace(config.Transport))
+	switch config.Transport {
+	case "":
+		config.Transport = ResolverTransportUDP
+	case ResolverTransportUDP, ResolverTransportTCP:
+	default:
+		return newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid resolver transport: %q (expected udp or tcp)", config.Transport))
+	}
+
+	if config.Mode == ResolverModeCustom {
+		if len(config.Servers) == 0 {
+			config.Servers = append([]string(nil), defaultResolverServers...)
+		}
+		for i, server := range config.Servers {
+			addr, err := resolverServerAddr(server)
+			if err != nil {
+				return err
+			}
+			config.Servers[i] = addr
+		}
+	}
+
+	if config.Mode == ResolverModeDoH {
+		if err := validateDoHConfig(config); err != nil {
+			return err
+		}
+	}
+
+	if config.TimeoutMs <= 0 {
+		config.TimeoutMs = int(defaultResolverTimeout / time.Millisecond)
+	}
+	return nil
+}
+
+// resolverServerAddr validates a DNS server and adds the default port
+func resolverServerAddr(server string) (string, error) {
+	server = strings.TrimSpace(server)
+	if ip := net.ParseIP(strings.Trim(server, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), "53"), nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) == nil {
+		return "", newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid DNS server %q: expected an IP address", server))
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", newTunnelError(ErrCodeInvalidConfig, fmt.Errorf("invalid DNS server port in %q", server))
+	}
+	return server, nil
+}
+
+// errDNSQuery is wrapped by the error of a lookup that failed on every upstream
+var errDNSQuery = errors.New("DNS query failed")
+
+// resolverUpstream is one entry in the resolver's fallback order
+type resolverUpstream struct {
+	name     string
+	resolver *net.Resolver
+}
+
+// tunnelResolver performs the tunnel's DNS lookups, trying each upstream in order
+// and logging the time taken by every attempt
+type tunnelResolver struct {
+	upstreams []resolverUpstream
+	doh       *http.Client
+	timeout   time.Duration
+	dial      dialFunc
+	logf      func(level int, format string, args ...interface{})
+}
+
+// newTunnelResolver builds the fallback chain described by config
+func newTunnelResolver(config *ResolverConfig, dial dialFunc, logf func(level int, format string, args ...interface{})) *tunnelResolver {
+	r := &tunnelResolver{
+		timeout: time.Duration(config.TimeoutMs) * time.Millisecond,
+		dial:    dial,
+		logf:    logf,
+	}
+	if config.Mode == ResolverModeCustom {
+		for _, server := range config.Servers {
+			r.upstreams = append(r.upstreams, resolverUpstream{
+				name:     server + "/" + config.Transport,
+				resolver: dnsServerResolver(server, config.Transport, r.timeout, dial),
+			})
+		}
+	}
+	if config.Mode == ResolverModeDoH {
+		r.doh = newDoHClient(config, r.timeout, dial)
+		r.upstreams = append(r.upstreams, resolverUpstream{
+			name:     config.DoHURL,
+			resolver: dohResolver(r.doh, config.DoHURL),
+		})
+	}
+	if config.Mode == ResolverModeSystem || config.FallbackToSystem {
+		r.upstreams = append(r.upstreams, resolverUpstream{name: "system", resolver: &net.Resolver{}})
+	}
+	return r
+}
+
+// dnsServerResolver returns a resolver that sends every query to server
+func dnsServerResolver(server string, transport string, timeout time.Duration, dial dialFunc) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dial(ctx, &net.Dialer{Timeout: timeout}, transport, server)
+		},
+	}
+}
+
+// Close releases the connections kept open to the DoH server
+func (r *tunnelResolver) Close() {
+	if r.doh != nil {
+		r.doh.CloseIdleConnections()
+	}
+}
+
+// lookup runs query against each upstream until one succeeds
+func (r *tunnelResolver) lookup(ctx context.Context, kind string, name string, query func(ctx context.Context, resolver *net.Resolver) (int, error)) error {
+	var errs []error
+	for _, upstream := range r.upstreams {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, r.timeout)
+		start := time.Now()
+		count, err := query(lookupCtx, upstream.resolver)
+		cancel()
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err == nil {
+			r.logf(0, "[dns] %s %s via %s: %d records in %s", kind, name, upstream.name, count, elapsed)
+			return nil
+		}
+		r.logf(1, "[dns] %s %s via %s failed after %s: %v", kind, name, upstream.name, elapsed, err)
+		errs = append(errs, fmt.Errorf("%s: %w", upstream.name, err))
+	}
+	if len(errs) == 0 {
+		return &net.DNSError{Err: "no resolver configured", Name: name}
+	}
+	return fmt.Errorf("%w for %s: %w", errDNSQuery, name, errors.Join(errs...))
+}
+
+// LookupSRV resolves an SRV record
+func (r *tunnelResolver) LookupSRV(ctx context.Context, service, proto, name string) ([]*net.SRV, error) {
+	var records []*net.SRV
+	err := r.lookup(ctx, "SRV", fmt.Sprintf("_%s._%s.%s", service, proto, name), func(ctx context.Context, resolver *net.Resolver) (int, error) {
+		_, srvs, err := resolver.LookupSRV(ctx, service, proto, name)
+		records = srvs
+		return len(srvs), err
+	})
+	return records, err
+}
+
+// LookupIP resolves the IPv4 and IPv6 addresses of host
+func (r *tunnelResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	var ips []net.IP
+	err := r.lookup(ctx, "IP", host, func(ctx context.Context, resolver *net.Resolver) (int, error) {
+		result, err := resolver.LookupIP(ctx, "ip", host)
+		ips = result
+		return len(result), err
+	})
+	return ips, err
+}
+
+// LookupTXT resolves the TXT records of name
+func (r *tunnelResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	var records []string
+	err := r.lookup(ctx, "TXT", name, func(ctx context.Context, resolver *net.Resolver) (int, error) {
+		result, err := resolver.LookupTXT(ctx, name)
+		records = result
+		return len(result), err
+	})
+	return records, err
+}
+
+// DialContext resolves the host with the tunnel resolver and dials its addresses in turn
+func (r *tunnelResolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return r.dial(ctx, &net.Dialer{}, network, address)
+	}
+
+	ips, err := r.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := r.dial(ctx, &net.Dialer{}, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// resolveEdgeAddrs discovers the edge addresses the way cloudflared does (SRV
+// records, then their A/AAAA records) but through the tunnel resolver.
+// IPv4 addresses are listed first, matching cloudflared's auto IP version.
+func (r *tunnelResolver) resolveEdgeAddrs(ctx context.Context, region string) ([]string, error) {
+	service := edgeSRVService
+	if region != "" {
+		service = region + "-" + edgeSRVService
+	}
+	srvs, err := r.LookupSRV(ctx, service, edgeSRVProto, edgeSRVName)
+	if err != nil {
+		return nil, err
+	}
+
+	var v4, v6 []string
+	for _, srv := range srvs {
+		ips, err := r.LookupIP(ctx, srv.Target)
+		if err != nil {
+			continue
+		}
+		port := strconv.Itoa(int(srv.Port))
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				v4 = append(v4, net.JoinHostPort(ip.String(), port))
+			} else {
+				v6 = append(v6, net.JoinHostPort(ip.String(), port))
+			}
+		}
+	}
+	sort.Strings(v4)
+	sort.Strings(v6)
+	addrs := append(v4, v6...)
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no edge addresses found", Name: edgeSRVName}
+	}
+	return addrs, nil
+}
+
+// resolver returns the resolver for the tunnel's next lookups
+func (t *Tunnel) resolver() *tunnelResolver {
+	t.mu.RLock()
+	config := t.config.Resolver
+	t.mu.RUnlock()
+
+	if config == nil {
+		config = defaultResolverConfig()
+	}
+	return newTunnelResolver(config, t.dial, t.logCallback)
+}
+
+// SetResolverConfig sets how the tunnel resolves DNS, as a JSON ResolverConfig, e.g.
+// {"mode": "custom", "servers": ["8.8.8.8"], "transport": "tcp", "fallbackToSystem": true} or
+// {"mode": "doh", "dohUrl": "https://dns.google/dns-query", "bootstrapIps": ["8.8.8.8"]}.
+// Takes effect on the next Start.
+func (t *Tunnel) SetResolverConfig(configJSON string) error {
+	config, err := parseResolverConfig(configJSON)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.config.Resolver = config
+	t.mu.Unlock()
+	return nil
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// SetResolverConfig sets the DNS resolver configuration for tunnels started
+// later with the static API. An empty string restores the default.
+func SetResolverConfig(configJSON string) error {
+	var config *ResolverConfig
+	if configJSON != "" {
+		parsed, err := parseResolverConfig(configJSON)
+		if err != nil {
+			return err
+		}
+		config = parsed
+	}
+
+	resolverSettingsMu.Lock()
+	globalResolverConfig = config
+	resolverSettingsMu.Unlock()
+	return nil
+}
+
+// SetResolverConfigByID sets the DNS resolver configuration of a tunnel created with CreateTunnel
+func SetResolverConfigByID(id string, configJSON string) error {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return err
+	}
+	return tunnel.SetResolverConfig(configJSON)
+}
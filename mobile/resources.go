@@ -0,0 +1,210 @@
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// resourceCounter counts the goroutines and sockets the wrapper started that are
+// currently alive
+type resourceCounter struct {
+	goroutines atomic.Int64
+	sockets    atomic.Int64
+}
+
+// wrapperResources counts the goroutines and sockets of all tunnels
+var wrapperResources resourceCounter
+
+// dialFunc opens a connection with d, counting it as one of the tunnel's sockets
+type dialFunc func(ctx context.Context, d *net.Dialer, network, address string) (net.Conn, error)
+
+// RuntimeStats is returned as JSON by GetRuntimeStats
+type RuntimeStats struct {
+	// Goroutines is the number of goroutines in the process
+	Goroutines int `json:"goroutines"`
+	// TunnelGoroutines is the number of goroutines started by the tunnel wrapper
+	TunnelGoroutines int64 `json:"tunnelGoroutines"`
+	// OpenSockets is the number of sockets open in the process, or -1 if unknown
+	OpenSockets int `json:"openSockets"`
+	// WrapperSockets is the number of sockets opened by the tunnel wrapper itself
+	// (metrics listener, DNS and quick tunnel requests). cloudflared dials its
+	// edge connections itself, so those only show up in OpenSockets.
+	WrapperSockets int64  `json:"wrapperSockets"`
+	HeapAllocBytes uint64 `json:"heapAllocBytes"`
+	HeapInuseBytes uint64 `json:"heapInuseBytes"`
+	HeapObjects    uint64 `json:"heapObjects"`
+}
+
+// trackGoroutine counts a goroutine against the tunnel. Call the returned
+// function when it exits.
+func (t *Tunnel) trackGoroutine() (done func()) {
+	t.resources.goroutines.Add(1)
+	wrapperResources.goroutines.Add(1)
+	return func() {
+		t.resources.goroutines.Add(-1)
+		wrapperResources.goroutines.Add(-1)
+	}
+}
+
+// goTracked runs fn in a counted goroutine that belongs to the current run.
+// start waits for these goroutines before reporting the tunnel stopped.
+func (t *Tunnel) goTracked(fn func()) {
+	done := t.trackGoroutine()
+	t.runGoroutines.Add(1)
+	go func() {
+		defer t.runGoroutines.Done()
+		defer done()
+		fn()
+	}()
+}
+
+// trackSocket counts a socket against the tunnel. Call the returned function
+// when it is closed; extra calls are ignored.
+func (t *Tunnel) trackSocket() (closed func()) {
+	t.resources.sockets.Add(1)
+	wrapperResources.sockets.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.resources.sockets.Add(-1)
+			wrapperResources.sockets.Add(-1)
+		})
+	}
+}
+
+// dial opens a connection counted against the tunnel
+func (t *Tunnel) dial(ctx context.Context, d *net.Dialer, network, address string) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return t.trackConn(conn), nil
+}
+
+// trackConn counts conn against the tunnel until it is closed. Packet
+// connections stay packet connections, which the DNS client relies on.
+func (t *Tunnel) trackConn(conn net.Conn) net.Conn {
+	tracked := &trackedConn{Conn: conn, closed: t.trackSocket()}
+	if packet, ok := conn.(net.PacketConn); ok {
+		return &trackedPacketConn{trackedConn: tracked, packet: packet}
+	}
+	return tracked
+}
+
+// trackListener counts a listener and the connections it accepts against the tunnel
+func (t *Tunnel) trackListener(listener net.Listener) net.Listener {
+	return &trackedListener{Listener: listener, tunnel: t, closed: t.trackSocket()}
+}
+
+type trackedConn struct {
+	net.Conn
+	closed func()
+}
+
+func (c *trackedConn) Close() error {
+	c.closed()
+	return c.Conn.Close()
+}
+
+type trackedPacketConn struct {
+	*trackedConn
+	packet net.PacketConn
+}
+
+func (c *trackedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return c.packet.ReadFrom(b)
+}
+
+func (c *trackedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.packet.WriteTo(b, addr)
+}
+
+type trackedListener struct {
+	net.Listener
+	tunnel *Tunnel
+	closed func()
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.tunnel.trackConn(conn), nil
+}
+
+func (l *trackedListener) Close() error {
+	l.closed()
+	return l.Listener.Close()
+}
+
+// openSockets counts the sockets open in the process. It returns -1 where
+// /proc is not available.
+func openSockets() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	count := 0
+	for _, entry := range entries {
+		target, err := os.Readlink("/proc/self/fd/" + entry.Name())
+		if err == nil && strings.HasPrefix(target, "socket:") {
+			count++
+		}
+	}
+	return count
+}
+
+// runtimeStats collects the process statistics along with the given counters
+func runtimeStats(counter *resourceCounter) string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := RuntimeStats{
+		Goroutines:       runtime.NumGoroutine(),
+		TunnelGoroutines: counter.goroutines.Load(),
+		OpenSockets:      openSockets(),
+		WrapperSockets:   counter.sockets.Load(),
+		HeapAllocBytes:   mem.HeapAlloc,
+		HeapInuseBytes:   mem.HeapInuse,
+		HeapObjects:      mem.HeapObjects,
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// GetRuntimeStats returns the process statistics as JSON, with the goroutines
+// and sockets the wrapper started for this tunnel
+func (t *Tunnel) GetRuntimeStats() string {
+	return runtimeStats(&t.resources)
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// GetRuntimeStats returns the process statistics as JSON: goroutines, open
+// sockets and heap usage, with the goroutines and sockets the wrapper started
+// for all tunnels
+func GetRuntimeStats() string {
+	return runtimeStats(&wrapperResources)
+}
+
+// GetRuntimeStatsByID returns the process statistics as JSON, with the
+// goroutines and sockets the wrapper started for a tunnel created with CreateTunnel
+func GetRuntimeStatsByID(id string) (string, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return "", err
+	}
+	return tunnel.GetRuntimeStats(), nil
+}
@@ -12,7 +12,10 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/rs/zerolog"
 )
 
 // ServerState represents the current state of the local server
@@ -74,6 +77,18 @@ type LocalServer struct {
 	cancel     context.CancelFunc
 	requestLog []RequestLog
 	maxLogs    int
+
+	// Counters for the metrics snapshot, not trimmed with requestLog
+	totalRequests  atomic.Int64
+	errorResponses atomic.Int64
+	bytesSent      atomic.Int64
+}
+
+// ServerMetrics holds the local server counters reported by GetTunnelMetrics
+type ServerMetrics struct {
+	TotalRequests  int64 `json:"totalRequests"`
+	ErrorResponses int64 `json:"errorResponses"`
+	BytesSent      int64 `json:"bytesSent"`
 }
 
 var (
@@ -232,6 +247,15 @@ func (s *LocalServer) GetRequestLogs() string {
 	return string(data)
 }
 
+// GetMetrics returns the server counters
+func (s *LocalServer) GetMetrics() ServerMetrics {
+	return ServerMetrics{
+		TotalRequests:  s.totalRequests.Load(),
+		ErrorResponses: s.errorResponses.Load(),
+		BytesSent:      s.bytesSent.Load(),
+	}
+}
+
 // ClearRequestLogs clears all logged requests
 func (s *LocalServer) ClearRequestLogs() {
 	s.mu.Lock()
@@ -247,7 +271,7 @@ func (s *LocalServer) createHandler() http.Handler {
 		start := time.Now()
 
 		// Create response wrapper to capture status code
-		wrapper := &responseWrapper{ResponseWriter: w, statusCode: 200}
+		wrapper := &responseWrapper{ResponseWriter: w, statusCode: 200, bytesSent: &s.bytesSent}
 
 		// Read body for logging (if not too large)
 		var bodyStr string
@@ -296,6 +320,29 @@ func (s *LocalServer) logRequest(r *http.Request, statusCode int, duration time.
 		Duration:    duration.Milliseconds(),
 	}
 
+	s.totalRequests.Add(1)
+	if statusCode >= 500 {
+		s.errorResponses.Add(1)
+	}
+
+	// Keep the request in the shared log buffer
+	level := zerolog.InfoLevel
+	if statusCode >= 500 {
+		level = zerolog.ErrorLevel
+	} else if statusCode >= 400 {
+		level = zerolog.WarnLevel
+	}
+	logs.add(level, LogEvent{
+		Source:  LogSourceServer,
+		Level:   level.String(),
+		Time:    log.Timestamp,
+		Message: fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, statusCode),
+		Fields: map[string]interface{}{
+			"remoteAddr": r.RemoteAddr,
+			"durationMs": log.Duration,
+		},
+	})
+
 	// Store log
 	s.mu.Lock()
 	s.requestLog = append(s.requestLog, log)
@@ -320,10 +367,11 @@ func (s *LocalServer) notifyState(state ServerState, message string) {
 	}
 }
 
-// responseWrapper wraps http.ResponseWriter to capture status code
+// responseWrapper wraps http.ResponseWriter to capture status code and bytes written
 type responseWrapper struct {
 	http.ResponseWriter
 	statusCode int
+	bytesSent  *atomic.Int64
 }
 
 func (w *responseWrapper) WriteHeader(code int) {
@@ -331,6 +379,12 @@ func (w *responseWrapper) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
+func (w *responseWrapper) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytesSent.Add(int64(n))
+	return n, err
+}
+
 // ============================================================================
 // Static functions for gomobile binding
 // ============================================================================
@@ -0,0 +1,151 @@
+package mobile
+
+import (
+	"fmt"
+	"time"
+)
+
+// forcedStopTimeout bounds how long a forced stop waits for the run to return
+const forcedStopTimeout = 10 * time.Second
+
+// signalStop asks the running tunnel to shut down and returns the channel closed
+// when the run has finished, or nil if the tunnel is not running. A graceful stop
+// lets the connections drain in-flight requests; a forced stop also cancels the
+// run's context so everything returns immediately.
+func (t *Tunnel) signalStop(force bool) chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel == nil {
+		return nil
+	}
+	if !t.stopping {
+		close(t.graceShutdownC)
+		t.stopping = true
+	}
+	if force && !t.stopForced {
+		t.stopForced = true
+		t.cancel()
+	}
+	return t.done
+}
+
+// stopRequested reports whether Stop or StopWithTimeout was called for the current run
+func (t *Tunnel) stopRequested() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.stopping || (t.ctx != nil && t.ctx.Err() != nil)
+}
+
+// stopMessage describes how the current run was stopped
+func (t *Tunnel) stopMessage() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	switch {
+	case t.stopForced:
+		return "Tunnel stopped (forced)"
+	case t.stopping:
+		return "Tunnel stopped gracefully"
+	default:
+		return "Tunnel stopped"
+	}
+}
+
+// StopWithTimeout stops the tunnel and blocks until it has fully shut down.
+// Connections first get up to timeoutMs to drain in-flight requests (the drain
+// itself is bounded by GracePeriodMs); after that the shutdown is forced.
+// timeoutMs <= 0 forces it immediately. It returns true if the tunnel stopped
+// gracefully and false if it had to be forced, or an error if it did not stop
+// even then. Do not call it from a tunnel callback.
+func (t *Tunnel) StopWithTimeout(timeoutMs int) (bool, error) {
+	done := t.signalStop(timeoutMs <= 0)
+	if done == nil {
+		t.stopIdle()
+		return true, nil
+	}
+
+	if timeoutMs > 0 {
+		timeout, stop := waitTimer(timeoutMs)
+		select {
+		case <-done:
+			stop()
+			t.logCallback(0, "[Stop] Tunnel stopped gracefully")
+			return true, nil
+		case <-timeout:
+		}
+		t.logCallback(1, "[Stop] Connections did not drain within %dms, forcing shutdown", timeoutMs)
+		t.signalStop(true)
+	}
+
+	timer := time.NewTimer(forcedStopTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		t.logCallback(0, "[Stop] Tunnel stopped (forced)")
+		return false, nil
+	case <-timer.C:
+		return false, fmt.Errorf("tunnel did not stop within %s", forcedStopTimeout)
+	}
+}
+
+// stopInBackground forces the tunnel to stop without blocking the caller and
+// returns the channel closed when the run has finished, or nil if it wasn't
+// running. A goroutine awaits the shutdown to report a tunnel that doesn't stop.
+func (t *Tunnel) stopInBackground() <-chan struct{} {
+	done := t.signalStop(true)
+	if done == nil {
+		t.stopIdle()
+		return nil
+	}
+
+	goroutineDone := t.trackGoroutine()
+	go func() {
+		defer goroutineDone()
+		timer := time.NewTimer(forcedStopTimeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+			t.logCallback(0, "[Stop] Tunnel stopped (forced)")
+		case <-timer.C:
+			t.logCallback(2, "[Stop] Tunnel did not stop within %s", forcedStopTimeout)
+		}
+	}()
+	return done
+}
+
+// stopIdle clears a failed state when Stop is called on a tunnel that is not running
+func (t *Tunnel) stopIdle() {
+	t.mu.RLock()
+	failed := t.state == StateError
+	t.mu.RUnlock()
+	if failed {
+		t.setState(StateDisconnected, "Tunnel stopped")
+	}
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// StopTunnelWithTimeout stops the global tunnel and waits for it to shut down
+// (see Tunnel.StopWithTimeout). It returns true if the shutdown was graceful.
+func StopTunnelWithTimeout(timeoutMs int) (bool, error) {
+	tunnelMu.Lock()
+	tunnel := globalTunnel
+	globalTunnel = nil
+	tunnelMu.Unlock()
+
+	if tunnel == nil {
+		return true, nil
+	}
+	return tunnel.StopWithTimeout(timeoutMs)
+}
+
+// StopTunnelByIDWithTimeout stops a tunnel created with CreateTunnel and waits
+// for it to shut down. It returns true if the shutdown was graceful.
+func StopTunnelByIDWithTimeout(id string, timeoutMs int) (bool, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return false, err
+	}
+	return tunnel.StopWithTimeout(timeoutMs)
+}
@@ -0,0 +1,147 @@
+package mobile
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// assertStopped checks that the run behind handle has fully returned
+func assertStopped(t *testing.T, tunnel *Tunnel, handle *TunnelHandle) {
+	t.Helper()
+	if !handle.IsStopped() {
+		t.Error("tunnel still running after StopWithTimeout returned")
+	}
+	if n := tunnel.resources.goroutines.Load(); n != 0 {
+		t.Errorf("%d tracked goroutines still running after StopWithTimeout returned", n)
+	}
+	if state := TunnelState(tunnel.GetState()); state != StateDisconnected {
+		t.Errorf("state = %s after StopWithTimeout returned, want disconnected", state)
+	}
+}
+
+func TestTunnelStopWithTimeoutDrainsConnections(t *testing.T) {
+	edge := newFakeEdge(t)
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, recorder)
+	handle := startConnected(t, tunnel)
+
+	graceful, err := tunnel.StopWithTimeout(5000)
+	if err != nil || !graceful {
+		t.Fatalf("StopWithTimeout = %v, %v; want graceful", graceful, err)
+	}
+	assertStopped(t, tunnel, handle)
+	if !waitFor(5*time.Second, func() bool { return edge.getUnregistrations() == 2 }) {
+		t.Errorf("%d connections unregistered, want 2", edge.getUnregistrations())
+	}
+	states := recorder.getStates()
+	if last := states[len(states)-1]; last.Message != "Tunnel stopped gracefully" {
+		t.Errorf("last state notification = %+v", last)
+	}
+}
+
+func TestTunnelStopWithTimeoutForcesStuckShutdown(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.ignoreGraceShutdown()
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, recorder)
+	handle := startConnected(t, tunnel)
+
+	start := time.Now()
+	graceful, err := tunnel.StopWithTimeout(100)
+	if err != nil || graceful {
+		t.Fatalf("StopWithTimeout = %v, %v; want forced", graceful, err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("forced after %s, want the 100ms drain first", elapsed)
+	}
+	assertStopped(t, tunnel, handle)
+	if n := edge.getUnregistrations(); n != 0 {
+		t.Errorf("%d connections unregistered, want none", n)
+	}
+	states := recorder.getStates()
+	if last := states[len(states)-1]; last.State != StateDisconnected || last.Message != "Tunnel stopped (forced)" {
+		t.Errorf("last state notification = %+v", last)
+	}
+	if got := transitions(tunnel); got[len(got)-1] != "disconnected" || strings.Contains(strings.Join(got, ","), "reconnecting") {
+		t.Errorf("transitions = %v", got)
+	}
+}
+
+func TestTunnelStopWithoutTimeoutForcesImmediately(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.ignoreGraceShutdown()
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, recorder)
+	handle := startConnected(t, tunnel)
+
+	graceful, err := tunnel.StopWithTimeout(0)
+	if err != nil || graceful {
+		t.Fatalf("StopWithTimeout(0) = %v, %v; want forced", graceful, err)
+	}
+	assertStopped(t, tunnel, handle)
+	states := recorder.getStates()
+	if last := states[len(states)-1]; last.Message != "Tunnel stopped (forced)" {
+		t.Errorf("last state notification = %+v", last)
+	}
+
+	// The next run starts from a clean stop state
+	handle = startConnected(t, tunnel)
+	if graceful, err := tunnel.StopWithTimeout(0); err != nil || graceful {
+		t.Fatalf("second StopWithTimeout(0) = %v, %v; want forced", graceful, err)
+	}
+	assertStopped(t, tunnel, handle)
+}
+
+func TestTunnelStopWhenIdle(t *testing.T) {
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+
+	graceful, err := tunnel.StopWithTimeout(100)
+	if err != nil || !graceful {
+		t.Errorf("StopWithTimeout on an idle tunnel = %v, %v", graceful, err)
+	}
+	if got := transitions(tunnel); len(got) != 0 {
+		t.Errorf("transitions = %v, want none", got)
+	}
+
+	// Stopping a tunnel that failed clears its error state
+	edge.reject("Unauthorized: Invalid tunnel secret")
+	handle := tunnel.StartAsync()
+	if err := handle.WaitStopped(5000); err == nil {
+		t.Fatal("tunnel connected to an edge rejecting it")
+	}
+	if state := TunnelState(tunnel.GetState()); state != StateError {
+		t.Fatalf("state = %s after a rejected registration, want error", state)
+	}
+	if graceful, err := tunnel.StopWithTimeout(100); err != nil || !graceful {
+		t.Errorf("StopWithTimeout on a failed tunnel = %v, %v", graceful, err)
+	}
+	if state := TunnelState(tunnel.GetState()); state != StateDisconnected {
+		t.Errorf("state = %s after stopping a failed tunnel, want disconnected", state)
+	}
+}
+
+func TestStopTunnelByIDWithTimeout(t *testing.T) {
+	edge := newFakeEdge(t)
+	id := createFakeEdgeTunnel(t, edge)
+
+	handle, err := StartTunnelByIDAsync(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handle.WaitConnected(5000); err != nil {
+		t.Fatalf("tunnel did not connect: %v", err)
+	}
+	graceful, err := StopTunnelByIDWithTimeout(id, 5000)
+	if err != nil || !graceful {
+		t.Fatalf("StopTunnelByIDWithTimeout = %v, %v; want graceful", graceful, err)
+	}
+	if !handle.IsStopped() {
+		t.Error("tunnel still running after StopTunnelByIDWithTimeout returned")
+	}
+
+	if _, err := StopTunnelByIDWithTimeout("no-such-tunnel", 100); err == nil {
+		t.Error("StopTunnelByIDWithTimeout succeeded for an unknown ID")
+	}
+}
@@ -0,0 +1,159 @@
+package mobile
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// maxStateHistory is the number of transitions kept per tunnel
+const maxStateHistory = 100
+
+// validTransitions lists the states each state may move to
+var validTransitions = map[TunnelState][]TunnelState{
+	StateDisconnected: {StateConnecting},
+	StateConnecting:   {StateConnected, StateError, StateDisconnected},
+	StateConnected:    {StateReconnecting, StateError, StateDisconnected},
+	StateReconnecting: {StateConnected, StateError, StateDisconnected},
+	StateError:        {StateConnecting, StateDisconnected},
+}
+
+// StateTransition is one entry of the tunnel state history
+type StateTransition struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason"`
+	Timestamp string `json:"timestamp"`
+}
+
+// canTransition reports whether the state machine allows moving from one state to another
+func canTransition(from, to TunnelState) bool {
+	for _, allowed := range validTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// setStateLocked moves the tunnel to a new state and records the transition.
+// It must be called with t.mu held. It returns false if the tunnel is already
+// in that state, and an error if the transition is not allowed.
+func (t *Tunnel) setStateLocked(to TunnelState, reason string) (bool, error) {
+	from := t.state
+	if from == to {
+		return false, nil
+	}
+	if !canTransition(from, to) {
+		return false, fmt.Errorf("invalid state transition from %s to %s", from, to)
+	}
+
+	now := time.Now()
+	t.state = to
+	switch to {
+	case StateConnected:
+		// Uptime counts from the run's first connection; reconnects don't reset it
+		if t.connectedAt.IsZero() {
+			t.connectedAt = now
+		} else {
+			t.lastReconnectAt = now
+		}
+		if t.connectedC != nil {
+			select {
+			case <-t.connectedC:
+			default:
+				close(t.connectedC)
+			}
+		}
+	case StateDisconnected, StateError:
+		t.connectedAt = time.Time{}
+		t.lastReconnectAt = time.Time{}
+	}
+
+	t.stateHistory = append(t.stateHistory, StateTransition{
+		From:      from.String(),
+		To:        to.String(),
+		Reason:    reason,
+		Timestamp: now.Format(time.RFC3339Nano),
+	})
+	if len(t.stateHistory) > maxStateHistory {
+		t.stateHistory = t.stateHistory[len(t.stateHistory)-maxStateHistory:]
+	}
+	return true, nil
+}
+
+// setState moves the tunnel to a new state and notifies the callback.
+// Invalid transitions are logged and ignored.
+func (t *Tunnel) setState(to TunnelState, reason string) bool {
+	t.mu.Lock()
+	changed, err := t.setStateLocked(to, reason)
+	t.mu.Unlock()
+
+	if err != nil {
+		t.logCallback(1, "[state] %v (%s)", err, reason)
+		return false
+	}
+	if changed {
+		t.notifyState(to, reason)
+	}
+	return changed
+}
+
+// updateStateFromConnections drives connected/reconnecting from the HA connection status.
+// Connections closing during a stop are not a reconnect.
+func (t *Tunnel) updateStateFromConnections() {
+	if t.stopRequested() {
+		return
+	}
+	active := t.readyConnections()
+
+	t.mu.RLock()
+	state := t.state
+	t.mu.RUnlock()
+
+	switch {
+	case active == 0 && state == StateConnected:
+		t.setState(StateReconnecting, "All edge connections lost, reconnecting...")
+	case active > 0 && state == StateReconnecting:
+		t.setState(StateConnected, fmt.Sprintf("Tunnel reconnected via %s", t.GetProtocol()))
+	}
+}
+
+// GetStateHistory returns the timestamped state transitions as JSON
+func (t *Tunnel) GetStateHistory() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	history := t.stateHistory
+	if history == nil {
+		history = []StateTransition{}
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+// ============================================================================
+// Static functions for gomobile binding
+// ============================================================================
+
+// GetTunnelStateHistory returns the state transitions of the global tunnel as JSON
+func GetTunnelStateHistory() string {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	if globalTunnel == nil {
+		return "[]"
+	}
+	return globalTunnel.GetStateHistory()
+}
+
+// GetTunnelStateHistoryByID returns the state transitions of a tunnel created with CreateTunnel as JSON
+func GetTunnelStateHistoryByID(id string) (string, error) {
+	tunnel, err := lookupTunnel(id)
+	if err != nil {
+		return "", err
+	}
+	return tunnel.GetStateHistory(), nil
+}
@@ -0,0 +1,142 @@
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConnectionsClosingDuringStopAreNotReconnecting(t *testing.T) {
+	tunnel := newTunnel(&TunnelConfig{Token: testToken(t)}, nil)
+	tunnel.mu.Lock()
+	tunnel.ctx, tunnel.cancel = context.WithCancel(context.Background())
+	tunnel.graceShutdownC = make(chan struct{})
+	tunnel.connections = map[uint8]*ConnectionInfo{0: {State: ConnEventDisconnected}}
+	_, _ = tunnel.setStateLocked(StateConnecting, "")
+	_, _ = tunnel.setStateLocked(StateConnected, "")
+	tunnel.mu.Unlock()
+	defer tunnel.cancel()
+
+	// A graceful stop closes the connections while the state is still connected
+	tunnel.signalStop(false)
+	tunnel.updateStateFromConnections()
+	if got, want := transitions(tunnel), []string{"connecting", "connected"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions during stop = %v, want %v", got, want)
+	}
+
+	// Without a stop the same loss is a reconnect
+	tunnel.mu.Lock()
+	tunnel.stopping = false
+	tunnel.mu.Unlock()
+	tunnel.updateStateFromConnections()
+	if got, want := transitions(tunnel), []string{"connecting", "connected", "reconnecting"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	tests := []struct {
+		from, to TunnelState
+		allowed  bool
+	}{
+		{StateDisconnected, StateConnecting, true},
+		{StateDisconnected, StateConnected, false},
+		{StateConnecting, StateConnected, true},
+		{StateConnecting, StateReconnecting, false},
+		{StateConnected, StateReconnecting, true},
+		{StateConnected, StateConnecting, false},
+		{StateReconnecting, StateConnected, true},
+		{StateReconnecting, StateError, true},
+		{StateError, StateConnecting, true},
+		{StateError, StateConnected, false},
+	}
+	for _, tt := range tests {
+		tunnel := newTunnel(&TunnelConfig{Token: testToken(t)}, nil)
+		tunnel.mu.Lock()
+		tunnel.state = tt.from
+		changed, err := tunnel.setStateLocked(tt.to, "test")
+		state := tunnel.state
+		tunnel.mu.Unlock()
+
+		if tt.allowed && (!changed || err != nil || state != tt.to) {
+			t.Errorf("%s -> %s = %v, %v; want the transition", tt.from, tt.to, changed, err)
+		}
+		if !tt.allowed && (changed || err == nil || state != tt.from) {
+			t.Errorf("%s -> %s = %v, %v; want it rejected", tt.from, tt.to, changed, err)
+		}
+	}
+
+	// Staying in the same state is not a transition
+	tunnel := newTunnel(&TunnelConfig{Token: testToken(t)}, nil)
+	tunnel.mu.Lock()
+	changed, err := tunnel.setStateLocked(StateDisconnected, "test")
+	tunnel.mu.Unlock()
+	if changed || err != nil || len(transitions(tunnel)) != 0 {
+		t.Errorf("disconnected -> disconnected = %v, %v; want a no-op", changed, err)
+	}
+}
+
+func TestStateHistory(t *testing.T) {
+	tunnel := newTunnel(&TunnelConfig{Token: testToken(t)}, nil)
+	before := time.Now()
+	tunnel.setState(StateConnecting, "starting")
+	tunnel.setState(StateConnected, "up")
+
+	var history []StateTransition
+	if err := json.Unmarshal([]byte(tunnel.GetStateHistory()), &history); err != nil {
+		t.Fatalf("invalid state history: %v", err)
+	}
+	want := []StateTransition{
+		{From: "disconnected", To: "connecting", Reason: "starting"},
+		{From: "connecting", To: "connected", Reason: "up"},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("history = %+v, want %d transitions", history, len(want))
+	}
+	for i, transition := range history {
+		timestamp, err := time.Parse(time.RFC3339Nano, transition.Timestamp)
+		if err != nil || timestamp.Before(before.Truncate(time.Second)) {
+			t.Errorf("transition %d timestamp %q: %v", i, transition.Timestamp, err)
+		}
+		transition.Timestamp = ""
+		if transition != want[i] {
+			t.Errorf("transition %d = %+v, want %+v", i, transition, want[i])
+		}
+	}
+
+	// The history keeps the newest maxStateHistory transitions
+	for i := 0; i < maxStateHistory; i++ {
+		tunnel.setState(StateReconnecting, fmt.Sprintf("lost %d", i))
+		tunnel.setState(StateConnected, fmt.Sprintf("back %d", i))
+	}
+	if err := json.Unmarshal([]byte(tunnel.GetStateHistory()), &history); err != nil {
+		t.Fatalf("invalid state history: %v", err)
+	}
+	if len(history) != maxStateHistory || history[len(history)-1].Reason != fmt.Sprintf("back %d", maxStateHistory-1) {
+		t.Errorf("history has %d transitions ending with %+v, want the newest %d", len(history), history[len(history)-1], maxStateHistory)
+	}
+}
+
+func TestTunnelErrorsWhenReconnectRetriesRunOut(t *testing.T) {
+	edge := newFakeEdge(t)
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{Retries: 1}, recorder)
+	handle := startConnected(t, tunnel)
+
+	// The edge goes away for good
+	edge.listener.Close()
+	edge.dropConnections()
+
+	if err := handle.WaitStopped(5000); classifyError(err) != ErrCodeEdgeUnreachable {
+		t.Fatalf("run ended with %v, want edge unreachable", err)
+	}
+	if got, want := transitions(tunnel), []string{"connecting", "connected", "reconnecting", "error"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+	if errs := recorder.getErrors(); len(errs) != 1 || errs[0].Code != ErrCodeEdgeUnreachable {
+		t.Errorf("callback errors = %v, want one edge unreachable", errs)
+	}
+}
@@ -0,0 +1,343 @@
+package mobile
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startConnected starts tunnel and waits until all its HA connections are registered
+func startConnected(t *testing.T, tunnel *Tunnel) *TunnelHandle {
+	t.Helper()
+	handle := tunnel.StartAsync()
+	t.Cleanup(func() { _, _ = tunnel.StopWithTimeout(0) })
+	if err := handle.WaitConnected(5000); err != nil {
+		t.Fatalf("tunnel did not connect: %v", err)
+	}
+	want := uint(tunnel.config.HAConnections)
+	if !waitFor(5*time.Second, func() bool { return tunnel.readyConnections() == want }) {
+		t.Fatalf("%d of %d connections registered", tunnel.readyConnections(), want)
+	}
+	return handle
+}
+
+// tunnelInfo returns the decoded GetInfo of tunnel
+func tunnelInfo(t *testing.T, tunnel *Tunnel) TunnelInfo {
+	t.Helper()
+	var info TunnelInfo
+	if err := json.Unmarshal([]byte(tunnel.GetInfo()), &info); err != nil {
+		t.Fatalf("invalid tunnel info: %v", err)
+	}
+	return info
+}
+
+// assertErrorCode checks the error returned by Start and the one reported to the callback
+func assertErrorCode(t *testing.T, err error, recorder *callbackRecorder, want ErrorCode) {
+	t.Helper()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if code := classifyError(err); code != want {
+		t.Errorf("error code = %s, want %s (%v)", code, want, err)
+	}
+	errs := recorder.getErrors()
+	if len(errs) == 0 || errs[len(errs)-1].Code != want {
+		t.Errorf("callback errors = %v, want last code %s", errs, want)
+	}
+}
+
+func TestTunnelConnectsAndStopsGracefully(t *testing.T) {
+	edge := newFakeEdge(t)
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{Tags: map[string]string{"team": "ci"}}, recorder)
+
+	startConnected(t, tunnel)
+
+	info := tunnelInfo(t, tunnel)
+	if info.State != StateConnected.String() || info.Protocol != "quic" || info.AccountTag != "test-account" {
+		t.Errorf("unexpected tunnel info: %+v", info)
+	}
+	registrations := edge.getRegistrations()
+	if len(registrations) != 2 {
+		t.Fatalf("edge saw %d registrations, want 2", len(registrations))
+	}
+	for _, registration := range registrations {
+		if registration.TunnelID != info.TunnelID || registration.ConnectorID != info.ConnectorID {
+			t.Errorf("registration %+v does not match tunnel %s / connector %s", registration, info.TunnelID, info.ConnectorID)
+		}
+		if registration.Tags["platform"] != "mobile" || registration.Tags["team"] != "ci" {
+			t.Errorf("registration tags = %v", registration.Tags)
+		}
+	}
+
+	graceful, err := tunnel.StopWithTimeout(5000)
+	if err != nil || !graceful {
+		t.Fatalf("StopWithTimeout = %v, %v; want graceful", graceful, err)
+	}
+	if !waitFor(5*time.Second, func() bool { return edge.getUnregistrations() == 2 }) {
+		t.Errorf("%d connections unregistered, want 2", edge.getUnregistrations())
+	}
+
+	want := []string{"connecting", "connected", "disconnected"}
+	if got := transitions(tunnel); !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+	states := recorder.getStates()
+	if last := states[len(states)-1]; last.State != StateDisconnected || last.Message != "Tunnel stopped gracefully" {
+		t.Errorf("last state notification = %+v", last)
+	}
+	if errs := recorder.getErrors(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestTunnelRegistrationRejected(t *testing.T) {
+	edge := newFakeEdge(t)
+	// What the edge answers a connector whose token has the wrong secret
+	edge.reject("Unauthorized: Invalid tunnel secret")
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, recorder)
+
+	err := tunnel.Start()
+	assertErrorCode(t, err, recorder, ErrCodeAuthRejected)
+	if state := TunnelState(tunnel.GetState()); state != StateError {
+		t.Errorf("state = %s, want error", state)
+	}
+	want := []string{"connecting", "error"}
+	if got := transitions(tunnel); !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+
+	// Stop clears the error once the tunnel is not running
+	tunnel.Stop()
+	if state := TunnelState(tunnel.GetState()); state != StateDisconnected {
+		t.Errorf("state after Stop = %s, want disconnected", state)
+	}
+}
+
+func TestTunnelEdgeUnreachable(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.listener.Close()
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{Retries: 1}, recorder)
+
+	err := tunnel.Start()
+	assertErrorCode(t, err, recorder, ErrCodeEdgeUnreachable)
+}
+
+func TestTunnelConnectTimeout(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.holdRegistrations()
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{ConnectTimeoutMs: 200}, recorder)
+
+	start := time.Now()
+	err := tunnel.Start()
+	assertErrorCode(t, err, recorder, ErrCodeConnectTimeout)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("connect timeout took %s", elapsed)
+	}
+	if !waitFor(5*time.Second, func() bool { return edge.openConns() == 0 }) {
+		t.Errorf("%d edge connections left open", edge.openConns())
+	}
+}
+
+func TestTunnelInvalidToken(t *testing.T) {
+	edge := newFakeEdge(t)
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{Token: "not-a-token"}, recorder)
+
+	err := tunnel.Start()
+	assertErrorCode(t, err, recorder, ErrCodeInvalidToken)
+	if n := len(edge.getRegistrations()); n != 0 {
+		t.Errorf("edge saw %d registrations", n)
+	}
+}
+
+func TestTunnelAlreadyRunning(t *testing.T) {
+	edge := newFakeEdge(t)
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+	startConnected(t, tunnel)
+
+	err := tunnel.Start()
+	if code := classifyError(err); code != ErrCodeAlreadyRunning {
+		t.Errorf("second Start error = %v (%s), want AlreadyRunning", err, code)
+	}
+	if state := TunnelState(tunnel.GetState()); state != StateConnected {
+		t.Errorf("state = %s, want connected", state)
+	}
+}
+
+func TestTunnelStartWhileConnecting(t *testing.T) {
+	edge := newFakeEdge(t)
+	edge.holdRegistrations()
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+
+	handle := tunnel.StartAsync()
+	t.Cleanup(func() { _, _ = tunnel.StopWithTimeout(0) })
+	if !waitFor(5*time.Second, func() bool { return edge.openConns() > 0 }) {
+		t.Fatal("tunnel never dialed the edge")
+	}
+
+	err := tunnel.Start()
+	if code := classifyError(err); code != ErrCodeAlreadyRunning {
+		t.Errorf("Start while connecting error = %v (%s), want AlreadyRunning", err, code)
+	}
+	if state := TunnelState(tunnel.GetState()); state != StateConnecting {
+		t.Errorf("state = %s, want connecting", state)
+	}
+
+	// The first run must still be stoppable
+	if _, err := tunnel.StopWithTimeout(0); err != nil {
+		t.Fatalf("StopWithTimeout: %v", err)
+	}
+	if !handle.IsStopped() {
+		t.Error("first run still active after StopWithTimeout returned")
+	}
+	want := []string{"connecting", "disconnected"}
+	if got := transitions(tunnel); !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+}
+
+func TestTunnelReconnectsAfterEdgeDrop(t *testing.T) {
+	edge := newFakeEdge(t)
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, recorder)
+	startConnected(t, tunnel)
+
+	edge.dropConnections()
+
+	if !waitFor(5*time.Second, func() bool {
+		info := tunnelInfo(t, tunnel)
+		return info.ReconnectCount == 2 && tunnel.readyConnections() == 2
+	}) {
+		t.Fatalf("connections not re-registered: %s", tunnel.GetInfo())
+	}
+	if state := TunnelState(tunnel.GetState()); state != StateConnected {
+		t.Errorf("state = %s, want connected", state)
+	}
+	if n := len(edge.getRegistrations()); n != 4 {
+		t.Errorf("edge saw %d registrations, want 4", n)
+	}
+	if errs := recorder.getErrors(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestTunnelNotifyNetworkChanged(t *testing.T) {
+	edge := newFakeEdge(t)
+	recorder := &callbackRecorder{}
+	tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, recorder)
+	startConnected(t, tunnel)
+
+	if err := tunnel.NotifyNetworkChanged(); err != nil {
+		t.Fatalf("NotifyNetworkChanged: %v", err)
+	}
+
+	if !waitFor(5*time.Second, func() bool {
+		for _, state := range recorder.getStates() {
+			if strings.HasPrefix(state.Message, "Reconnected 2 connections") {
+				return true
+			}
+		}
+		return false
+	}) {
+		t.Fatalf("reconnect not reported, states: %v", recorder.getStates())
+	}
+	if n := len(edge.getRegistrations()); n != 4 {
+		t.Errorf("edge saw %d registrations, want 4", n)
+	}
+}
+
+func TestParseTunnelConfigKeepsZeroRetries(t *testing.T) {
+	token := testToken(t)
+	for _, tc := range []struct {
+		json string
+		want int
+	}{
+		{`{"token":"` + token + `"}`, defaultRetries},
+		{`{"token":"` + token + `","retries":-1}`, defaultRetries},
+		{`{"token":"` + token + `","retries":0}`, 0},
+		{`{"token":"` + token + `","retries":2}`, 2},
+	} {
+		config, err := parseTunnelConfig(tc.json)
+		if err != nil {
+			t.Fatalf("parseTunnelConfig(%s): %v", tc.json, err)
+		}
+		if got := intOrDefault(config.Retries, defaultRetries); got != tc.want {
+			t.Errorf("%s: retries = %d, want %d", tc.json, got, tc.want)
+		}
+	}
+	if _, err := parseTunnelConfig(`{"token":"` + token + `","retries":-2}`); classifyError(err) != ErrCodeInvalidConfig {
+		t.Errorf("retries -2 error = %v, want InvalidConfig", err)
+	}
+}
+
+func TestReplacingGlobalTunnelDoesNotBlock(t *testing.T) {
+	edge := newFakeEdge(t)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	releaseOnce := func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}
+	t.Cleanup(func() {
+		releaseOnce()
+		_, _ = StopTunnelWithTimeout(0)
+	})
+
+	stuck, err := replaceGlobalTunnel(func() (*Tunnel, error) {
+		tunnel := newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil)
+		tunnel.daemon = stuckDaemon(entered, release)
+		return tunnel, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuckHandle := stuck.StartAsync()
+	<-entered
+
+	// Replacing a tunnel whose shutdown hangs returns at once
+	begin := time.Now()
+	next, err := replaceGlobalTunnel(func() (*Tunnel, error) {
+		return newFakeEdgeTunnel(t, edge, &TunnelConfig{}, nil), nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("replacing the global tunnel took %s", elapsed)
+	}
+
+	// The replacement waits for the old tunnel instead of running alongside it
+	nextHandle := next.StartAsync()
+	if !waitFor(5*time.Second, func() bool { return TunnelState(next.GetState()) == StateConnecting }) {
+		t.Fatalf("replacement state = %s, want connecting", next.GetStateString())
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := len(edge.getRegistrations()); n != 0 || stuckHandle.IsStopped() {
+		t.Fatalf("replacement ran before the old tunnel stopped (%d registrations)", n)
+	}
+
+	begin = time.Now()
+	ForceReset()
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("ForceReset took %s", elapsed)
+	}
+	releaseOnce()
+	if err := stuckHandle.WaitStopped(5000); err != nil {
+		t.Fatalf("old tunnel: %v", err)
+	}
+	if err := nextHandle.WaitStopped(5000); err != nil {
+		t.Fatalf("replacement: %v", err)
+	}
+	if n := len(edge.getRegistrations()); n != 0 {
+		t.Errorf("replacement registered %d connections after ForceReset", n)
+	}
+}